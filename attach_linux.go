@@ -0,0 +1,118 @@
+// Copyright 2018 The up AUTHORS
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// attachProcess returns a reader yielding data written by a running process
+// to its standard output and error. If they are redirected to files, the
+// files are followed until the process exits; otherwise, writes of the
+// process are intercepted with ptrace.
+func attachProcess(pid int) (io.Reader, error) {
+	var files []string
+	var seen []os.FileInfo
+	for _, fd := range []int{1, 2} {
+		path := "/proc/" + strconv.Itoa(pid) + "/fd/" + strconv.Itoa(fd)
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.Mode().IsRegular() {
+			files = nil
+			break
+		}
+		if len(seen) == 0 || !os.SameFile(seen[0], info) {
+			files = append(files, path)
+			seen = append(seen, info)
+		}
+	}
+
+	r, w := io.Pipe()
+	if len(files) > 0 {
+		wg := sync.WaitGroup{}
+		for _, path := range files {
+			f, err := os.Open(path)
+			if err != nil {
+				return nil, err
+			}
+			wg.Add(1)
+			go func() {
+				err := followFile(f, pid, w)
+				if err != nil {
+					fmt.Fprintf(w, "up: %s\n", err)
+				}
+				wg.Done()
+			}()
+		}
+		go func() {
+			wg.Wait()
+			w.Close()
+		}()
+		return r, nil
+	}
+
+	// Writes are intercepted while the process is stopped, so they must never
+	// wait for our reader, or the traced process would hang.
+	writes := make(chan []byte, 1024)
+	go func() {
+		for data := range writes {
+			w.Write(data)
+		}
+		w.Close()
+	}()
+	started := make(chan error)
+	go func() {
+		err := traceWrites(pid, started, func(data []byte) {
+			select {
+			case writes <- data:
+			default:
+				// Data is lost, but the traced process keeps running
+			}
+		})
+		if err != nil {
+			writes <- []byte("up: " + err.Error() + "\n")
+		}
+		close(writes)
+	}()
+	err := <-started
+	if err != nil {
+		return nil, fmt.Errorf("cannot trace process %d: %s (see also: /proc/sys/kernel/yama/ptrace_scope)", pid, err)
+	}
+	return r, nil
+}
+
+// followFile copies data appended to f into w, until the process with pid
+// exits.
+func followFile(f *os.File, pid int, w io.Writer) error {
+	defer f.Close()
+	for {
+		// Check if the process is alive before reading, so that we don't miss
+		// anything it wrote just before exiting
+		alive := syscall.Kill(pid, 0) != syscall.ESRCH
+		_, err := io.Copy(w, f)
+		if err != nil || !alive {
+			return err
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
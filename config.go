@@ -0,0 +1,257 @@
+// Copyright 2018 The up AUTHORS
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// config holds settings read from a configuration file, grouped in sections.
+// Settings at the top of the file, before any [section] header, are in the
+// section with empty name. Every value is kept as a list of strings: scalar
+// values (strings, numbers, booleans) are lists with a single element.
+type config map[string]map[string][]string
+
+// defaultConfigPath returns the path of the configuration file used when
+// --config flag is not provided.
+func defaultConfigPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "up", "config.toml")
+}
+
+// loadConfig reads the configuration file at path. If the file does not
+// exist and mustExist is false, an empty config is returned.
+func loadConfig(path string, mustExist bool) (config, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && !mustExist {
+		return config{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	cfg, err := parseConfig(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s:%s", path, err)
+	}
+	return cfg, nil
+}
+
+// parseConfig parses a simple subset of TOML format: [section] headers, and
+// key = value lines, where value is a "string", a 'literal string', a bare
+// number or boolean, or an [array, of, such, values] (possibly spanning many
+// lines). Comments start with #.
+func parseConfig(text string) (config, error) {
+	cfg := config{"": {}}
+	section := ""
+	lines := strings.Split(text, "\n")
+	for i := 0; i < len(lines); i++ {
+		lineno := i + 1
+		line := strings.TrimSpace(stripComment(lines[i]))
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "["):
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("%d: missing ']' in section header", lineno)
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if cfg[section] == nil {
+				cfg[section] = map[string][]string{}
+			}
+			continue
+		}
+
+		eq := strings.IndexByte(line, '=')
+		if eq == -1 {
+			return nil, fmt.Errorf("%d: expected 'key = value'", lineno)
+		}
+		name, err := parseConfigKey(strings.TrimSpace(line[:eq]))
+		if err != nil {
+			return nil, fmt.Errorf("%d: %s", lineno, err)
+		}
+		value := strings.TrimSpace(line[eq+1:])
+		// Arrays may span multiple lines; collect them until the closing bracket
+		for strings.HasPrefix(value, "[") && !strings.HasSuffix(value, "]") && i+1 < len(lines) {
+			i++
+			value += " " + strings.TrimSpace(stripComment(lines[i]))
+		}
+		values, err := parseConfigValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("%d: %s", lineno, err)
+		}
+		if _, dup := cfg[section][name]; dup {
+			return nil, fmt.Errorf("%d: duplicate key %q", lineno, name)
+		}
+		cfg[section][name] = values
+	}
+	return cfg, nil
+}
+
+func parseConfigKey(s string) (string, error) {
+	if strings.HasPrefix(s, `"`) || strings.HasPrefix(s, `'`) {
+		values, err := parseConfigValue(s)
+		if err != nil {
+			return "", err
+		}
+		return values[0], nil
+	}
+	if s == "" || strings.IndexFunc(s, func(r rune) bool {
+		return !(r == '-' || r == '_' || '0' <= r && r <= '9' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z')
+	}) != -1 {
+		return "", fmt.Errorf("invalid key %q", s)
+	}
+	return s, nil
+}
+
+func parseConfigValue(s string) ([]string, error) {
+	if strings.HasPrefix(s, "[") {
+		if !strings.HasSuffix(s, "]") {
+			return nil, fmt.Errorf("missing ']' at end of array")
+		}
+		values := []string{}
+		rest := strings.TrimSpace(s[1 : len(s)-1])
+		for rest != "" {
+			elem, n, err := scanScalar(rest)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, elem)
+			rest = strings.TrimSpace(rest[n:])
+			if rest == "" {
+				break
+			}
+			if rest[0] != ',' {
+				return nil, fmt.Errorf("expected ',' between array elements, got: %s", rest)
+			}
+			rest = strings.TrimSpace(rest[1:])
+		}
+		return values, nil
+	}
+	value, n, err := scanScalar(s)
+	if err != nil {
+		return nil, err
+	}
+	if n != len(s) {
+		return nil, fmt.Errorf("unexpected text after value: %s", s[n:])
+	}
+	return []string{value}, nil
+}
+
+// scanScalar parses a single value at the beginning of s, returning it
+// together with the number of bytes it occupied.
+func scanScalar(s string) (value string, n int, err error) {
+	if s == "" {
+		return "", 0, fmt.Errorf("missing value")
+	}
+	switch s[0] {
+	case '"':
+		// Go escape sequences are close enough to TOML ones
+		for i := 1; i < len(s); i++ {
+			switch s[i] {
+			case '\\':
+				i++
+			case '"':
+				value, err = strconv.Unquote(s[:i+1])
+				return value, i + 1, err
+			}
+		}
+		return "", 0, fmt.Errorf("unterminated string: %s", s)
+	case '\'':
+		end := strings.IndexByte(s[1:], '\'')
+		if end == -1 {
+			return "", 0, fmt.Errorf("unterminated string: %s", s)
+		}
+		return s[1 : end+1], end + 2, nil
+	}
+	n = strings.IndexAny(s, ", \t]")
+	if n == -1 {
+		n = len(s)
+	}
+	value = s[:n]
+	if _, err := strconv.ParseFloat(value, 64); err != nil && value != "true" && value != "false" {
+		return "", 0, fmt.Errorf("invalid value %q (strings must be quoted)", value)
+	}
+	return value, n, nil
+}
+
+// stripComment removes a # comment from the line, if any.
+func stripComment(line string) string {
+	quote := byte(0)
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote == 0 && c == '#':
+			return line[:i]
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == '"' && c == '\\':
+			i++
+		case quote == c:
+			quote = 0
+		}
+	}
+	return line
+}
+
+// checkSections verifies that the config contains only known sections.
+func (cfg config) checkSections(known ...string) error {
+	for name := range cfg {
+		found := name == ""
+		for _, k := range known {
+			found = found || name == k
+		}
+		if !found {
+			return fmt.Errorf("unknown section in config: [%s]", name)
+		}
+	}
+	return nil
+}
+
+// applyFlags sets the command-line flags to the values from the top-level
+// section of the config, unless they were explicitly set on command line.
+// Flags set from the config are marked as changed, like the ones set on
+// command line.
+func (cfg config) applyFlags(flags *pflag.FlagSet) error {
+	for name, values := range cfg[""] {
+		f := flags.Lookup(name)
+		if f == nil || name == "config" {
+			return fmt.Errorf("unknown option in config: %s", name)
+		}
+		if f.Changed {
+			// Command line has priority over config file
+			continue
+		}
+		for _, v := range values {
+			err := flags.Set(name, v)
+			if err != nil {
+				return fmt.Errorf("invalid value for option %s in config: %s", name, err)
+			}
+		}
+	}
+	return nil
+}
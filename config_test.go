@@ -0,0 +1,134 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+func Test_parseConfig(t *testing.T) {
+	tests := []struct {
+		comment string
+		text    string
+		want    config
+		wantErr bool
+	}{
+		{
+			comment: "empty file",
+			text:    "",
+			want:    config{"": {}},
+		},
+		{
+			comment: "scalar values of all kinds",
+			text: `
+# comment
+unsafe-full-throttle = true
+buf = 100   # trailing comment
+pipeline = "grep \"#\" | wc -l"
+output-script = 'C:\up.sh'
+`,
+			want: config{"": {
+				"unsafe-full-throttle": {"true"},
+				"buf":                  {"100"},
+				"pipeline":             {`grep "#" | wc -l`},
+				"output-script":        {`C:\up.sh`},
+			}},
+		},
+		{
+			comment: "arrays, including multi-line ones",
+			text: `
+exec = ["bash", "-c"]
+empty = []
+[keys]
+quit = [
+  "Ctrl-C",  # comment
+  'Ctrl-D',
+]
+`,
+			want: config{
+				"":     {"exec": {"bash", "-c"}, "empty": {}},
+				"keys": {"quit": {"Ctrl-C", "Ctrl-D"}},
+			},
+		},
+		{
+			comment: "unquoted string",
+			text:    `pipeline = grep`,
+			wantErr: true,
+		},
+		{
+			comment: "unterminated string",
+			text:    `pipeline = "grep`,
+			wantErr: true,
+		},
+		{
+			comment: "duplicate key",
+			text:    "buf = 1\nbuf = 2",
+			wantErr: true,
+		},
+		{
+			comment: "missing value",
+			text:    "buf",
+			wantErr: true,
+		},
+		{
+			comment: "empty value",
+			text:    "buf =",
+			wantErr: true,
+		},
+		{
+			comment: "empty value in section",
+			text:    "[keys]\nquit =   # comment",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		have, err := parseConfig(tt.text)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: bad error: %v", tt.comment, err)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(have, tt.want) {
+			t.Errorf("%q: bad config\nwant: %q\nhave: %q", tt.comment, tt.want, have)
+		}
+	}
+}
+
+func Test_config_applyFlags(t *testing.T) {
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	unsafe := flags.Bool("unsafe-full-throttle", false, "")
+	buf := flags.Int("buf", 40, "")
+	exec := flags.StringArrayP("exec", "e", nil, "")
+	err := flags.Parse([]string{"--buf=10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config{"": {
+		"unsafe-full-throttle": {"true"},
+		"buf":                  {"100"},
+		"exec":                 {"bash", "-c"},
+	}}
+	err = cfg.applyFlags(flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *unsafe != true {
+		t.Errorf("bad unsafe-full-throttle: %v", *unsafe)
+	}
+	if *buf != 10 {
+		t.Errorf("command line should override config, bad buf: %v", *buf)
+	}
+	if !reflect.DeepEqual(*exec, []string{"bash", "-c"}) {
+		t.Errorf("bad exec: %q", *exec)
+	}
+	if !flags.Changed("unsafe-full-throttle") {
+		t.Errorf("flag set from config should be marked as changed")
+	}
+
+	err = config{"": {"no-such-flag": {"1"}}}.applyFlags(flags)
+	if err == nil {
+		t.Errorf("expected error for unknown flag")
+	}
+}
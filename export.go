@@ -0,0 +1,137 @@
+// Copyright 2018 The up AUTHORS
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// exportFormat describes a way of saving the pipeline with Ctrl-X other than
+// as a script: as a definition appended to a file, like a shell function.
+type exportFormat struct {
+	name string // its first letter is typed by user to choose the format
+	file string // suggested file to append the definition to
+	// multiline is false if the definition can't have a multi-line command;
+	// Make and just run each line of a recipe in a separate shell
+	multiline bool
+	// format returns the definition of a target called name, running command
+	format func(name, command string) string
+}
+
+var exportFormats = []exportFormat{
+	{"function", "", true, shellFunction},
+	{"alias", "", true, shellAlias},
+	{"Makefile target", "Makefile", false, makefileTarget},
+	{"justfile recipe", "justfile", false, justfileRecipe},
+}
+
+// exportChoices returns the list of export formats, as shown to user when
+// asking them to choose one.
+func exportChoices() string {
+	var choices []string
+	for _, f := range exportFormats {
+		word := strings.Fields(f.name)[0]
+		choices = append(choices, "["+word[:1]+"]"+word[1:])
+	}
+	return strings.Join(choices, ", ")
+}
+
+// findExportFormat returns the export format chosen with key, if any.
+func findExportFormat(key string) *exportFormat {
+	for i := range exportFormats {
+		if strings.EqualFold(exportFormats[i].name[:1], key) {
+			return &exportFormats[i]
+		}
+	}
+	return nil
+}
+
+// exportPipeline appends the definition of a target called name, running
+// command in the given format, to a file at path (creating it if needed).
+func exportPipeline(format *exportFormat, name, command, path string) error {
+	if !validExportName(name) {
+		return fmt.Errorf("invalid name: %q", name)
+	}
+	if !format.multiline && strings.Contains(command, "\n") {
+		return fmt.Errorf("multi-line pipeline can't be saved as %s, as each line would run in a separate shell", format.name)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	text := format.format(name, command)
+	// Separate the definition from any earlier contents of the file
+	if info, err := f.Stat(); err == nil && info.Size() > 0 {
+		text = "\n" + text
+	}
+	_, err = f.WriteString(text)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// validExportName returns true if name can be used as a name of a function,
+// alias, or Makefile and justfile target without quoting.
+func validExportName(name string) bool {
+	if name == "" || name[0] == '-' || '0' <= name[0] && name[0] <= '9' {
+		return false
+	}
+	return strings.IndexFunc(name, func(r rune) bool {
+		return !(r == '-' || r == '_' || '0' <= r && r <= '9' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z')
+	}) == -1
+}
+
+func shellFunction(name, command string) string {
+	return name + "() {\n\t" + command + "\n}\n"
+}
+
+func shellAlias(name, command string) string {
+	return "alias " + name + "=" + shellQuote(command) + "\n"
+}
+
+func makefileTarget(name, command string) string {
+	// Make expands variables in recipes even inside quotes, so '$' must be
+	// doubled to reach the shell
+	return ".PHONY: " + name + "\n" + name + ":\n\t" + strings.Replace(command, "$", "$$", -1) + "\n"
+}
+
+func justfileRecipe(name, command string) string {
+	// In just, '{{' starts an interpolation; a literal one is written '{{{{'
+	return name + ":\n    " + strings.Replace(command, "{{", "{{{{", -1) + "\n"
+}
+
+// shellJoin joins args into a command line for POSIX shells, quoting the ones
+// which need it.
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = arg
+		if arg == "" || strings.IndexFunc(arg, func(r rune) bool {
+			return !(strings.ContainsRune("-_./:=,+@%", r) || '0' <= r && r <= '9' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z')
+		}) != -1 {
+			quoted[i] = shellQuote(arg)
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+// shellQuote quotes s so that it's read as a single word by POSIX shells.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
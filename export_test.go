@@ -0,0 +1,130 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_exportFormats(t *testing.T) {
+	command := `grep -v '#' | awk '{print $1}' | sed 's/{{x}}/y/'`
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"f", "foo() {\n\tgrep -v '#' | awk '{print $1}' | sed 's/{{x}}/y/'\n}\n"},
+		{"a", `alias foo='grep -v '\''#'\'' | awk '\''{print $1}'\'' | sed '\''s/{{x}}/y/'\'''` + "\n"},
+		{"m", ".PHONY: foo\nfoo:\n\tgrep -v '#' | awk '{print $$1}' | sed 's/{{x}}/y/'\n"},
+		{"j", "foo:\n    grep -v '#' | awk '{print $1}' | sed 's/{{{{x}}/y/'\n"},
+	}
+	for _, tt := range tests {
+		have := findExportFormat(tt.format).format("foo", command)
+		if have != tt.want {
+			t.Errorf("%q: bad export\nwant: %q\nhave: %q", tt.format, tt.want, have)
+		}
+	}
+}
+
+func Test_shellQuote(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{"", "''"},
+		{"ls -l", "'ls -l'"},
+		{"it's", `'it'\''s'`},
+		{"$HOME `x` \\", "'$HOME `x` \\'"},
+	}
+	for _, tt := range tests {
+		if have := shellQuote(tt.s); have != tt.want {
+			t.Errorf("%q: want %s, have %s", tt.s, tt.want, have)
+		}
+	}
+}
+
+func Test_exportPipeline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "up-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "functions.sh")
+
+	format := findExportFormat("f")
+	for _, name := range []string{"one", "two"} {
+		err := exportPipeline(format, name, "wc -l", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	have, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "one() {\n\twc -l\n}\n\ntwo() {\n\twc -l\n}\n"
+	if string(have) != want {
+		t.Errorf("bad file\nwant: %q\nhave: %q", want, have)
+	}
+
+	if exportPipeline(format, "bad name", "wc -l", path) == nil {
+		t.Errorf("expected error for invalid name")
+	}
+}
+
+func Test_exportPipeline_multiline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "up-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	command := "grep x |\n# count them\nwc -l"
+	tests := []struct {
+		format string
+		want   string // empty if the command should be rejected
+	}{
+		{"f", "foo() {\n\tgrep x |\n# count them\nwc -l\n}\n"},
+		{"a", "alias foo='grep x |\n# count them\nwc -l'\n"},
+		{"m", ""},
+		{"j", ""},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.format)
+		err := exportPipeline(findExportFormat(tt.format), "foo", command, path)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%q: expected error for multi-line command", tt.format)
+			}
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				t.Errorf("%q: file should not be created", tt.format)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", tt.format, err)
+			continue
+		}
+		have, _ := ioutil.ReadFile(path)
+		if string(have) != tt.want {
+			t.Errorf("%q: bad file\nwant: %q\nhave: %q", tt.format, tt.want, have)
+		}
+	}
+}
+
+func Test_shellJoin(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"ls", "-l", "/tmp/a.log"}, "ls -l /tmp/a.log"},
+		{[]string{"kubectl", "get", "pods", "--selector=app=web"}, "kubectl get pods --selector=app=web"},
+		{[]string{"printf", "a\nb", ""}, "printf 'a\nb' ''"},
+		{[]string{"sh", "-c", "echo $HOME; it's"}, `sh -c 'echo $HOME; it'\''s'`},
+	}
+	for _, tt := range tests {
+		if have := shellJoin(tt.args); have != tt.want {
+			t.Errorf("%q: want %s, have %s", tt.args, tt.want, have)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2018 The up AUTHORS
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// expandFiles returns paths of input files given on command line. Arguments
+// which are not names of existing files are treated as glob patterns (this
+// allows quoting them, so that they're not expanded by shell).
+func expandFiles(args []string) ([]string, error) {
+	var paths []string
+	for _, arg := range args {
+		if _, err := os.Stat(arg); err == nil || !strings.ContainsAny(arg, `*?[`) {
+			paths = append(paths, arg)
+			continue
+		}
+		matches, err := filepath.Glob(arg)
+		if err != nil {
+			return nil, fmt.Errorf("bad pattern %q: %s", arg, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no files match %q", arg)
+		}
+		paths = append(paths, matches...)
+	}
+	for _, path := range paths {
+		if _, err := os.Stat(path); err != nil {
+			return nil, err
+		}
+	}
+	return paths, nil
+}
+
+// setFileVars exposes paths of input files to pipelines, as environment
+// variables $UP_FILE1, $UP_FILE2, etc.
+func setFileVars(paths []string) {
+	for i, path := range paths {
+		os.Setenv("UP_FILE"+strconv.Itoa(i+1), path)
+	}
+}
+
+// readFiles returns a reader yielding concatenated contents of files at
+// paths, decompressed if needed. Errors are reported in the data, in the same
+// way as errors of pipelines. Closing the reader stops reading the files.
+func readFiles(paths []string) io.ReadCloser {
+	r, w := io.Pipe()
+	go func() {
+		for _, path := range paths {
+			f, err := openFile(path)
+			if err == nil {
+				_, err = io.Copy(w, f)
+				if cerr := f.Close(); err == nil {
+					err = cerr
+				}
+			}
+			if err == io.ErrClosedPipe {
+				return
+			} else if err != nil {
+				fmt.Fprintf(w, "up: %s: %s\n", path, err)
+			}
+		}
+		w.Close()
+	}()
+	// Closing the writer makes the reader see EOF, while any pending writes fail
+	return readCloser{r, w}
+}
+
+// openFile opens a file for reading, transparently decompressing it if it is
+// compressed with gzip, bzip2 or zstd.
+func openFile(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(f)
+	magic, _ := r.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		z, err := gzip.NewReader(r)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return readCloser{z, f}, nil
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		return readCloser{bzip2.NewReader(r), f}, nil
+	case bytes.Equal(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		// There's no zstd decompressor in Go standard library
+		cmd := exec.Command("zstd", "-dc")
+		cmd.Stdin = r
+		out, err := cmd.StdoutPipe()
+		if err == nil {
+			err = cmd.Start()
+		}
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("cannot decompress zstd: %s", err)
+		}
+		return readCloser{out, closerFunc(func() error {
+			out.Close()
+			err := cmd.Wait()
+			f.Close()
+			if err != nil {
+				return fmt.Errorf("zstd: %s", err)
+			}
+			return nil
+		})}, nil
+	}
+	return readCloser{r, f}, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_expandFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "up-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"a.log", "b.log", "c.txt", "[x].txt"} {
+		err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	in := func(names ...string) []string {
+		for i := range names {
+			names[i] = filepath.Join(dir, names[i])
+		}
+		return names
+	}
+
+	tests := []struct {
+		comment string
+		args    []string
+		want    []string
+		wantErr bool
+	}{
+		{
+			comment: "plain names",
+			args:    in("c.txt", "a.log"),
+			want:    in("c.txt", "a.log"),
+		},
+		{
+			comment: "glob pattern",
+			args:    in("*.log", "c.txt"),
+			want:    in("a.log", "b.log", "c.txt"),
+		},
+		{
+			comment: "existing file with glob characters in name",
+			args:    in("[x].txt"),
+			want:    in("[x].txt"),
+		},
+		{
+			comment: "pattern matching nothing",
+			args:    in("*.gz"),
+			wantErr: true,
+		},
+		{
+			comment: "missing file",
+			args:    in("d.txt"),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		have, err := expandFiles(tt.args)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: bad error: %v", tt.comment, err)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(have, tt.want) {
+			t.Errorf("%q: bad paths\nwant: %q\nhave: %q", tt.comment, tt.want, have)
+		}
+	}
+}
+
+func Test_readFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "up-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var gz bytes.Buffer
+	z := gzip.NewWriter(&gz)
+	z.Write([]byte("compressed\n"))
+	z.Close()
+	plain, compressed := filepath.Join(dir, "plain.txt"), filepath.Join(dir, "compressed.gz")
+	ioutil.WriteFile(plain, []byte("plain\n"), 0644)
+	ioutil.WriteFile(compressed, gz.Bytes(), 0644)
+
+	have, err := ioutil.ReadAll(readFiles([]string{plain, compressed}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "plain\ncompressed\n"; string(have) != want {
+		t.Errorf("want: %q\nhave: %q", want, have)
+	}
+}
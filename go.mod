@@ -1,12 +1,19 @@
 module github.com/akavel/up
 
-go 1.14
+go 1.18
 
 require (
-	github.com/gdamore/tcell v1.4.0
+	github.com/gdamore/tcell/v2 v2.6.0
 	github.com/mattn/go-isatty v0.0.3
-	github.com/mattn/go-runewidth v0.0.9 // indirect
 	github.com/spf13/pflag v1.0.3
-	golang.org/x/sys v0.0.0-20201029080932-201ba4db2418 // indirect
-	golang.org/x/text v0.3.4 // indirect
+	golang.org/x/sys v0.5.0
+)
+
+require (
+	github.com/gdamore/encoding v1.0.0 // indirect
+	github.com/lucasb-eyer/go-colorful v1.2.0 // indirect
+	github.com/mattn/go-runewidth v0.0.14 // indirect
+	github.com/rivo/uniseg v0.4.3 // indirect
+	golang.org/x/term v0.5.0 // indirect
+	golang.org/x/text v0.7.0 // indirect
 )
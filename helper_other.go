@@ -0,0 +1,39 @@
+// Copyright 2018 The up AUTHORS
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux && !darwin && !netbsd
+// +build !linux,!darwin,!netbsd
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// sigXCPU is never delivered, as CPU time limits are not supported here.
+const sigXCPU = syscall.Signal(-1)
+
+func peakMemory(state *os.ProcessState) int64 { return 0 }
+
+func helperCommand(cmd *exec.Cmd, spec string) error {
+	return errors.New("resource limits and sandbox are not supported on this platform")
+}
+
+func runHelper(spec string, args []string) {
+	os.Stderr.WriteString("up: resource limits and sandbox are not supported on this platform\n")
+	os.Exit(126)
+}
@@ -0,0 +1,138 @@
+// Copyright 2018 The up AUTHORS
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux || darwin || netbsd
+// +build linux darwin netbsd
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// sigXCPU is the signal sent to a process which exceeded its CPU time limit.
+const sigXCPU = syscall.SIGXCPU
+
+// peakMemory returns the biggest resident set size, in bytes, of the finished
+// process or any of its children which it waited for. If the process was
+// a helper, this includes the size of the helper itself, before it executed
+// the command.
+func peakMemory(state *os.ProcessState) int64 {
+	usage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return 0
+	}
+	if runtime.GOOS == "darwin" {
+		return int64(usage.Maxrss)
+	}
+	return int64(usage.Maxrss) * 1024
+}
+
+// helperCommand modifies cmd so that up is first re-executed as a helper
+// process, which prepares the environment described by spec, then executes
+// the original command (see runHelper).
+func helperCommand(cmd *exec.Cmd, spec string) error {
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd.Args = append([]string{"up", cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = self
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, helperEnv+"="+spec)
+	return nil
+}
+
+// runHelper prepares the environment described by comma-separated options in
+// spec, then executes the command from args. Supported options are:
+//   - sandbox: finish preparing a sandbox (see sandboxCommand); options
+//     after it are applied inside the sandbox
+//   - cpu=N: limit CPU time of the command to N seconds
+//   - mem=N: limit address space of the command to N bytes
+func runHelper(spec string, args []string) {
+	fail := func(err error) {
+		os.Stderr.WriteString("up: cannot prepare pipeline environment: " + err.Error() + "\n")
+		os.Exit(126)
+	}
+	// Privileges, limits and the seccomp filter are set for the current
+	// thread, which must then start the command
+	runtime.LockOSThread()
+	os.Unsetenv(helperEnv)
+	if len(args) == 0 {
+		fail(fmt.Errorf("no command to run"))
+	}
+	opts := strings.Split(spec, ",")
+	for i, opt := range opts {
+		name, value := opt, ""
+		if i := strings.IndexByte(opt, '='); i != -1 {
+			name, value = opt[:i], opt[i+1:]
+		}
+		var err error
+		switch name {
+		case "sandbox":
+			err = enterSandbox()
+			if err == nil {
+				// The rest of options must not apply to the first process in
+				// the sandbox, so it runs a new helper with them
+				var code int
+				code, err = sandboxInit(strings.Join(opts[i+1:], ","), args)
+				if err == nil {
+					os.Exit(code)
+				}
+			}
+		case "cpu":
+			err = setLimit(syscall.RLIMIT_CPU, value, 1)
+		case "mem":
+			err = setLimit(syscall.RLIMIT_AS, value, 0)
+		default:
+			err = fmt.Errorf("unknown option: %q", opt)
+		}
+		if err != nil {
+			fail(err)
+		}
+	}
+	fail(syscall.Exec(args[0], args, os.Environ()))
+}
+
+// setLimit sets the soft limit of a resource to value, and the hard limit to
+// value+extra. The extra margin allows the process to be notified with a
+// signal when the soft limit is exceeded, before it's killed.
+func setLimit(resource int, value string, extra uint64) error {
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return err
+	}
+	var limit syscall.Rlimit
+	err = syscall.Getrlimit(resource, &limit)
+	if err != nil {
+		return err
+	}
+	if n+extra < limit.Max {
+		limit.Max = n + extra
+	}
+	if n < limit.Max {
+		limit.Cur = n
+	} else {
+		limit.Cur = limit.Max
+	}
+	return syscall.Setrlimit(resource, &limit)
+}
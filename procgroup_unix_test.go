@@ -0,0 +1,48 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func Test_Subprocess_Wait(t *testing.T) {
+	// The inner shell ignores SIGTERM, and prints its pid, which is then
+	// taken over by sleep
+	p := StartSubprocess([]string{"/bin/sh", "-c"}, `sh -c 'trap "" TERM; echo $$; exec sleep 30' | cat`, NewBufString(strings.Repeat("x\n", 100)), func() {})
+	line, err := bufio.NewReader(p.Buf.NewReader(true)).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Kill()
+	p.Wait()
+
+	// The killed process may remain a zombie until it's reaped
+	alive := func() bool {
+		if syscall.Kill(pid, 0) == syscall.ESRCH {
+			return false
+		}
+		stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+		return err != nil || !strings.Contains(string(stat), ") Z ")
+	}
+	deadline := time.Now().Add(time.Second)
+	for alive() {
+		if time.Now().After(deadline) {
+			syscall.Kill(pid, syscall.SIGKILL)
+			t.Fatalf("process ignoring SIGTERM still running after Wait")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,248 @@
+// Copyright 2018 The up AUTHORS
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// rpcServer serves JSON-RPC 2.0 requests on a unix socket. Each request,
+// response and notification is a single line of JSON text.
+type rpcServer struct {
+	listener net.Listener
+	path     string
+	handle   rpcHandler
+
+	mu    sync.Mutex // guards the following fields
+	conns map[*rpcConn]bool
+}
+
+// rpcHandler handles a call of a JSON-RPC method, returning its result.
+type rpcHandler func(method string, params json.RawMessage) (interface{}, error)
+
+// rpcError is an error with a JSON-RPC error code.
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string { return e.Message }
+
+// Error codes defined by JSON-RPC 2.0 specification; application errors use
+// rpcFailed.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcFailed         = -32000
+)
+
+type rpcRequest struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Method  string           `json:"method"`
+	Params  json.RawMessage  `json:"params"`
+}
+
+type rpcResponse struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Result  *json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError        `json:"error,omitempty"`
+}
+
+type rpcNotification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+// listenRPC starts serving JSON-RPC requests on a unix socket at path, in
+// background. Only the current user is allowed to connect to the socket.
+func listenRPC(path string, handle rpcHandler) (*rpcServer, error) {
+	// Remove a socket left by an earlier instance, unless it's still in use
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("socket %s is already in use", path)
+		}
+		os.Remove(path)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chmod(path, 0600)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	s := &rpcServer{listener: l, path: path, handle: handle, conns: map[*rpcConn]bool{}}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				log.Printf("rpc: accept: %s", err)
+				return
+			}
+			c := &rpcConn{conn: conn}
+			s.mu.Lock()
+			s.conns[c] = true
+			s.mu.Unlock()
+			go s.serve(c)
+		}
+	}()
+	return s, nil
+}
+
+// Close stops the server, and removes its socket. It does nothing on nil
+// server.
+func (s *rpcServer) Close() {
+	if s == nil {
+		return
+	}
+	s.listener.Close()
+	os.Remove(s.path)
+}
+
+// Notify sends a notification to all connected clients. The clients are
+// written to in parallel, so that a slow one doesn't delay the others.
+func (s *rpcServer) Notify(method string, params interface{}) {
+	data, err := json.Marshal(rpcNotification{JSONRPC: "2.0", Method: method, Params: params})
+	if err != nil {
+		log.Printf("rpc: %s", err)
+		return
+	}
+	s.mu.Lock()
+	conns := make([]*rpcConn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, c := range conns {
+		wg.Add(1)
+		go func(c *rpcConn) {
+			defer wg.Done()
+			c.write(data)
+		}(c)
+	}
+	wg.Wait()
+}
+
+func (s *rpcServer) serve(c *rpcConn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		c.conn.Close()
+	}()
+	scanner := bufio.NewScanner(c.conn)
+	scanner.Buffer(nil, 64*1024*1024)
+	for scanner.Scan() {
+		var req rpcRequest
+		err := json.Unmarshal(scanner.Bytes(), &req)
+		switch {
+		case err != nil:
+			err = &rpcError{Code: rpcParseError, Message: err.Error()}
+		case req.JSONRPC != "2.0" || req.Method == "":
+			err = &rpcError{Code: rpcInvalidRequest, Message: "invalid JSON-RPC 2.0 request"}
+		}
+		var result interface{}
+		if err == nil {
+			result, err = s.handle(req.Method, req.Params)
+		}
+		if req.ID == nil && !malformed(err) {
+			// Notifications from client don't get responses, even if they
+			// failed; only requests which can't be read get them (with null
+			// id)
+			continue
+		}
+		resp := rpcResponse{JSONRPC: "2.0", ID: req.ID}
+		if err == nil {
+			var data json.RawMessage
+			data, err = json.Marshal(result)
+			resp.Result = &data
+		}
+		if err != nil {
+			rerr := &rpcError{}
+			if !errors.As(err, &rerr) {
+				rerr = &rpcError{Code: rpcFailed, Message: err.Error()}
+			}
+			resp.Result, resp.Error = nil, rerr
+		}
+		c.send(resp)
+	}
+}
+
+// malformed returns true if err is a parse error or invalid request error.
+func malformed(err error) bool {
+	rerr := &rpcError{}
+	return errors.As(err, &rerr) && (rerr.Code == rpcParseError || rerr.Code == rpcInvalidRequest)
+}
+
+// rpcWriteTimeout is how long a client may not read messages sent to it,
+// before it's disconnected.
+const rpcWriteTimeout = 10 * time.Second
+
+// rpcConn is a connection of a single client.
+type rpcConn struct {
+	conn net.Conn
+	mu   sync.Mutex // serializes writes
+}
+
+func (c *rpcConn) send(msg interface{}) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("rpc: %s", err)
+		return
+	}
+	c.write(data)
+}
+
+// write sends a JSON message, followed by a newline, to the client.
+func (c *rpcConn) write(data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Don't let a client which doesn't read its messages block us forever
+	c.conn.SetWriteDeadline(time.Now().Add(rpcWriteTimeout))
+	_, err := c.conn.Write(append(data, '\n'))
+	if err != nil {
+		log.Printf("rpc: %s", err)
+		c.conn.Close()
+	}
+}
+
+// parseParams decodes params of a method call into v.
+func parseParams(params json.RawMessage, v interface{}) error {
+	if len(params) == 0 || string(params) == "null" {
+		return nil
+	}
+	err := json.Unmarshal(params, v)
+	if err != nil {
+		return &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+// Copyright 2018 The up AUTHORS
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// sandboxCommand modifies cmd so that it is run in new user, mount, network
+// and PID namespaces. The sandbox must be then finished from inside the
+// namespaces by a helper process (see runHelper and enterSandbox).
+func sandboxCommand(cmd *exec.Cmd) error {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Cloneflags = syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWNET | syscall.CLONE_NEWPID
+	cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getuid(), HostID: os.Getuid(), Size: 1}}
+	cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getgid(), HostID: os.Getgid(), Size: 1}}
+	cmd.SysProcAttr.GidMappingsEnableSetgroups = false
+	// All processes in the sandbox are killed with the first one, so make
+	// sure it doesn't outlive us
+	cmd.SysProcAttr.Pdeathsig = syscall.SIGKILL
+	return nil
+}
+
+// enterSandbox is run in the helper process started with sandboxCommand. It
+// makes all filesystems read-only, except for a new empty tmpfs at /tmp,
+// mounts /proc showing only processes in the sandbox, forbids connecting to
+// unix sockets, and drops all privileges.
+func enterSandbox() error {
+	filter, err := socketFilter()
+	if err != nil {
+		return err
+	}
+	// Don't let our changes propagate outside the sandbox
+	err = syscall.Mount("none", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, "")
+	if err != nil {
+		return err
+	}
+	mounts, err := readMounts()
+	if err != nil {
+		return err
+	}
+	for _, m := range mounts {
+		err := syscall.Mount("", m.path, "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY|m.flags, "")
+		if err != nil && !pseudoFilesystem(m.path) {
+			return err
+		}
+	}
+	err = syscall.Mount("tmpfs", "/tmp", "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "")
+	if err != nil {
+		return err
+	}
+	err = syscall.Mount("proc", "/proc", "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, "")
+	if err != nil {
+		return err
+	}
+
+	// Make sure the pipeline can't regain privileges needed to undo the above
+	for c := 0; c <= unix.CAP_LAST_CAP; c++ {
+		err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(c), 0, 0, 0)
+		if err != nil && err != syscall.EINVAL {
+			return err
+		}
+	}
+	err = unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0)
+	if err != nil {
+		return err
+	}
+	caps := [2]unix.CapUserData{} // all empty
+	err = unix.Capset(&unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}, &caps[0])
+	if err != nil {
+		return err
+	}
+	return unix.Prctl(unix.PR_SET_SECCOMP, unix.SECCOMP_MODE_FILTER, uintptr(unsafe.Pointer(filter)), 0, 0)
+}
+
+// sandboxInit runs the command from args as a child of the helper, which is
+// the first process in the PID namespace of the sandbox: it must keep running
+// until all processes in the namespace exit, reaping the orphaned ones (see
+// `man 7 pid_namespaces`). Remaining helper options in spec are applied by
+// a new helper process, as they must not limit this one. It returns the exit
+// code of the command.
+func sandboxInit(spec string, args []string) (int, error) {
+	path, env := args[0], os.Environ()
+	if spec != "" {
+		// The path of up may be hidden in the sandbox, e.g. if it's in /tmp
+		path, args = "/proc/self/exe", append([]string{"up"}, args...)
+		env = append(env, helperEnv+"="+spec)
+	}
+	// Signals from outside the namespace, for which the first process has no
+	// handlers, are ignored; they are sent to the whole process group, so
+	// the pipeline gets them anyway
+	signal.Notify(make(chan os.Signal, 1), syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	p, err := os.StartProcess(path, args, &os.ProcAttr{Env: env, Files: []*os.File{os.Stdin, os.Stdout, os.Stderr}})
+	if err != nil {
+		return 0, err
+	}
+	code := 0
+	for {
+		var status syscall.WaitStatus
+		pid, err := syscall.Wait4(-1, &status, 0, nil)
+		if err == syscall.EINTR {
+			continue
+		} else if err != nil {
+			// No more processes in the namespace
+			return code, nil
+		}
+		if pid == p.Pid {
+			code = status.ExitStatus()
+			if status.Signaled() {
+				code = 128 + int(status.Signal())
+			}
+		}
+	}
+}
+
+// seccompArch lists architectures supported by socketFilter, where unix
+// sockets can be only created with socket syscall (e.g. not socketcall).
+var seccompArch = map[string]uint32{
+	"amd64":   unix.AUDIT_ARCH_X86_64,
+	"arm64":   unix.AUDIT_ARCH_AARCH64,
+	"arm":     unix.AUDIT_ARCH_ARM,
+	"riscv64": unix.AUDIT_ARCH_RISCV64,
+}
+
+// socketFilter returns a seccomp filter which forbids creating unix sockets,
+// so that the pipeline can't connect to services outside the sandbox (like
+// the Docker daemon, or D-Bus), even though their sockets can be found in the
+// filesystem. Sockets in the network namespace of the sandbox are harmless.
+// io_uring is also forbidden, as it could create sockets bypassing the filter.
+func socketFilter() (*unix.SockFprog, error) {
+	arch, ok := seccompArch[runtime.GOARCH]
+	if !ok {
+		return nil, errors.New("--sandbox is not supported on " + runtime.GOARCH)
+	}
+	const (
+		retAllow = 0x7fff0000 // SECCOMP_RET_ALLOW
+		retErrno = 0x00050000 // SECCOMP_RET_ERRNO
+		retKill  = 0x80000000 // SECCOMP_RET_KILL_PROCESS
+		x32      = 0x40000000 // __X32_SYSCALL_BIT
+		// Offsets of fields of struct seccomp_data
+		offsetNr   = 0
+		offsetArch = 4
+		offsetArg0 = 16 // low half on little-endian architectures
+	)
+	filter := []unix.SockFilter{
+		{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: offsetArch},
+		{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, K: arch, Jt: 1},
+		// Syscalls of other architectures, like 32-bit x86 on amd64
+		{Code: unix.BPF_RET | unix.BPF_K, K: retKill},
+		{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: offsetNr},
+		{Code: unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K, K: x32, Jf: 1},
+		{Code: unix.BPF_RET | unix.BPF_K, K: retErrno | uint32(syscall.ENOSYS)},
+		{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, K: unix.SYS_IO_URING_SETUP, Jf: 1},
+		{Code: unix.BPF_RET | unix.BPF_K, K: retErrno | uint32(syscall.ENOSYS)},
+		{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, K: unix.SYS_SOCKET, Jf: 3},
+		{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: offsetArg0},
+		{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, K: unix.AF_UNIX, Jf: 1},
+		{Code: unix.BPF_RET | unix.BPF_K, K: retErrno | uint32(syscall.EACCES)},
+		{Code: unix.BPF_RET | unix.BPF_K, K: retAllow},
+	}
+	return &unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}, nil
+}
+
+type mount struct {
+	path  string
+	flags uintptr
+}
+
+// readMounts lists all mount points visible to the process, together with
+// their flags which must be preserved when remounting them.
+func readMounts() ([]mount, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	flagNames := map[string]uintptr{
+		"nosuid":     syscall.MS_NOSUID,
+		"nodev":      syscall.MS_NODEV,
+		"noexec":     syscall.MS_NOEXEC,
+		"noatime":    syscall.MS_NOATIME,
+		"nodiratime": syscall.MS_NODIRATIME,
+		"relatime":   syscall.MS_RELATIME,
+	}
+	var mounts []mount
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// See "/proc/[pid]/mountinfo" in `man 5 proc` for format description
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 {
+			continue
+		}
+		m := mount{path: unescapeMountPath(fields[4])}
+		for _, opt := range strings.Split(fields[5], ",") {
+			m.flags |= flagNames[opt]
+		}
+		mounts = append(mounts, m)
+	}
+	return mounts, scanner.Err()
+}
+
+// unescapeMountPath decodes octal escapes like \040 used in mountinfo.
+func unescapeMountPath(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			c := (s[i+1]-'0')<<6 | (s[i+2]-'0')<<3 | (s[i+3] - '0')
+			b.WriteByte(c)
+			i += 3
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// pseudoFilesystem returns true for mount points of special filesystems,
+// which may refuse to be remounted read-only from inside a sandbox.
+func pseudoFilesystem(path string) bool {
+	for _, prefix := range []string{"/proc", "/sys", "/dev"} {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_Subprocess_Sandbox(t *testing.T) {
+	defer func(sandboxMode0 bool) { *sandboxMode = sandboxMode0 }(*sandboxMode)
+	*sandboxMode = true
+	run := func(command string) string {
+		// Size of the input is also the limit of size of the output
+		input := NewBufString(strings.Repeat("x\n", 1024))
+		p := StartSubprocess([]string{"/bin/sh", "-c"}, command, input, func() {})
+		output, _ := ioutil.ReadAll(p.Buf.NewReader(true))
+		return string(output)
+	}
+	if output := run("echo ok"); output != "ok\n" {
+		t.Skipf("sandbox not supported here: %s", output)
+	}
+
+	// A unix socket outside of /tmp, which is replaced in the sandbox
+	dir, err := ioutil.TempDir(".", "up-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "s")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	// A process outside of the sandbox
+	sleep := exec.Command("sleep", "30")
+	err = sleep.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sleep.Wait()
+	defer sleep.Process.Kill()
+
+	// The socket must be reachable outside the sandbox
+	connect := fmt.Sprintf(`python3 -c 'import socket; socket.socket(socket.AF_UNIX).connect("%s")' 2>/dev/null && echo connected || echo refused`, socket)
+	*sandboxMode = false
+	if have := run(connect); have != "connected\n" {
+		t.Skipf("cannot connect to unix socket with python3: %s", have)
+	}
+	*sandboxMode = true
+
+	tests := []struct {
+		comment string
+		command string
+		want    string
+	}{
+		{"network", "tail -n +3 /proc/net/dev | cut -d: -f1 | tr -d ' '", "lo\n"},
+		{"read-only files", "touch " + filepath.Join(dir, "f") + " 2>/dev/null || echo refused", "refused\n"},
+		{"empty /tmp", "ls /tmp; touch /tmp/f && echo ok", "ok\n"},
+		{"unix sockets", connect, "refused\n"},
+		{"processes outside", fmt.Sprintf("kill %d 2>/dev/null || echo refused", sleep.Process.Pid), "refused\n"},
+		{"/proc", fmt.Sprintf("ls /proc/%d 2>/dev/null || echo hidden", sleep.Process.Pid), "hidden\n"},
+	}
+	for _, tt := range tests {
+		if have := run(tt.command); have != tt.want {
+			t.Errorf("%s: want %q, have %q", tt.comment, tt.want, have)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+// Copyright 2018 The up AUTHORS
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// session is the state of a headless up, controlled with JSON-RPC requests
+// (see serve).
+type session struct {
+	shell []string
+	rpc   *rpcServer
+
+	mu         sync.Mutex // guards the following fields
+	input      *Buf
+	command    string
+	run        int // number of the current run, increased with each run
+	subprocess *Subprocess
+	output     *Buf
+	notifying  bool // is sending of outputChanged notification scheduled?
+}
+
+// serve runs up as a JSON-RPC server listening on a unix socket at path,
+// until it's interrupted with a signal.
+func serve(path string, shell []string, input *Buf) error {
+	s, err := newSession(path, shell, input)
+	if err != nil {
+		return err
+	}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	<-stop
+	s.Close()
+	return nil
+}
+
+// newSession starts serving JSON-RPC requests controlling a new session on
+// a unix socket at path.
+func newSession(path string, shell []string, input *Buf) (*session, error) {
+	s := &session{shell: shell, input: input, output: input}
+	rpc, err := listenRPC(path, s.handle)
+	if err != nil {
+		return nil, err
+	}
+	s.rpc = rpc
+	return s, nil
+}
+
+// Close stops the server, and kills the running pipeline.
+func (s *session) Close() {
+	s.rpc.Close()
+	s.mu.Lock()
+	s.subprocess.Kill()
+	s.subprocess.Wait()
+	s.mu.Unlock()
+}
+
+// outputState is sent in outputChanged notifications, and returned by some
+// methods.
+type outputState struct {
+	Run      int    `json:"run"`
+	Command  string `json:"command"`
+	Lines    int    `json:"lines"`
+	Complete bool   `json:"complete"`
+}
+
+func (s *session) handle(method string, params json.RawMessage) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch method {
+	case "setInput":
+		var p struct{ Text string }
+		if err := parseParams(params, &p); err != nil {
+			return nil, err
+		}
+		// Like input read in interactive mode, the text is limited by --buf,
+		// and output of pipelines can be as big
+		s.input = NewBuf(*bufsize*1024*1024).StartCapturing(strings.NewReader(p.Text), func() {})
+		return nil, nil
+	case "setPipeline":
+		var p struct{ Command string }
+		if err := parseParams(params, &p); err != nil {
+			return nil, err
+		}
+		s.command = p.Command
+		return nil, nil
+	case "run":
+		p := struct {
+			Command *string
+			Confirm bool // allow running a dangerous command (see --guard)
+		}{}
+		if err := parseParams(params, &p); err != nil {
+			return nil, err
+		}
+		if p.Command != nil {
+			s.command = *p.Command
+		}
+		if name := forbiddenCommand(s.command, *denyCommands, *allowCommands); name != "" && *guardMode != "off" {
+			if *guardMode == "refuse" || !p.Confirm {
+				return nil, errors.New("refusing to run dangerous command: " + name + " (see --guard option, and confirm parameter)")
+			}
+		}
+		s.start()
+		return s.state(), nil
+	case "cancel":
+		s.subprocess.Kill()
+		return s.state(), nil
+	case "getState":
+		return s.state(), nil
+	case "getOutput":
+		lines, err := getLines(s.output, params)
+		if err != nil {
+			return nil, err
+		}
+		return struct {
+			outputState
+			outputLines
+		}{s.state(), lines}, nil
+	}
+	return nil, &rpcError{Code: rpcMethodNotFound, Message: "unknown method: " + method}
+}
+
+// start kills the running pipeline, if any, and runs s.command over the input.
+func (s *session) start() {
+	s.subprocess.Kill()
+	s.subprocess = nil
+	s.run++
+	s.output = s.input
+	if s.command != "" {
+		run := s.run
+		s.subprocess = StartSubprocess(s.shell, s.command, s.input, func() { s.outputChanged(run) })
+		s.output = s.subprocess.Buf
+	}
+}
+
+// outputChanged notifies clients that output of the pipeline grew. To not
+// flood them, notifications are sent at most once in a short while.
+func (s *session) outputChanged(run int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.notifying || run != s.run {
+		return
+	}
+	s.notifying = true
+	time.AfterFunc(50*time.Millisecond, func() {
+		s.mu.Lock()
+		s.notifying = false
+		state := s.state()
+		s.mu.Unlock()
+		s.rpc.Notify("outputChanged", state)
+	})
+}
+
+func (s *session) state() outputState {
+	return outputState{
+		Run:      s.run,
+		Command:  s.command,
+		Lines:    countLines(s.output),
+		Complete: s.output.Complete(),
+	}
+}
+
+// remoteState is the state of the interactive interface, returned to clients
+// connected with --listen option.
+type remoteState struct {
+	Command  string `json:"command"`
+	Current  bool   `json:"current"`
+	Lines    int    `json:"lines"`
+	Complete bool   `json:"complete"`
+}
+
+// outputLines is a range of lines of output, returned by getOutput method.
+type outputLines struct {
+	From int      `json:"from"`
+	Text []string `json:"text"`
+}
+
+// getLines returns lines of text captured in buf, in range given by params of
+// getOutput method.
+func getLines(buf *Buf, params json.RawMessage) (outputLines, error) {
+	p := struct{ From, To int }{From: 0, To: -1}
+	if err := parseParams(params, &p); err != nil {
+		return outputLines{}, err
+	}
+	lines := bufLines(buf)
+	from, to := clamp(p.From, len(lines)), clamp(p.To, len(lines))
+	if p.To < 0 {
+		to = len(lines)
+	}
+	if to < from {
+		to = from
+	}
+	text := make([]string, 0, to-from)
+	for _, line := range lines[from:to] {
+		text = append(text, string(line))
+	}
+	return outputLines{From: from, Text: text}, nil
+}
+
+// countLines returns the number of lines of text captured in buf.
+func countLines(buf *Buf) int {
+	data := buf.Bytes()
+	lines := bytes.Count(data, []byte("\n"))
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		lines++
+	}
+	return lines
+}
+
+// bufLines returns lines of text captured in buf, without the empty line after
+// the final newline.
+func bufLines(buf *Buf) [][]byte {
+	lines := buf.Lines()
+	if n := len(lines); n > 0 && len(lines[n-1]) == 0 {
+		lines = lines[:n-1]
+	}
+	return lines
+}
+
+func clamp(i, n int) int {
+	if i < 0 {
+		return 0
+	} else if i > n {
+		return n
+	}
+	return i
+}
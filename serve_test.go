@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func Test_session(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no /bin/sh on Windows")
+	}
+	dir, err := ioutil.TempDir("", "up-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "up.sock")
+	s, err := newSession(path, []string{"/bin/sh", "-c"}, NewBufString("foo\nbar\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	lines := bufio.NewScanner(conn)
+	// call sends a request, and returns the response, skipping notifications
+	call := func(request string) map[string]interface{} {
+		_, err := conn.Write([]byte(request + "\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for lines.Scan() {
+			var msg map[string]interface{}
+			err := json.Unmarshal(lines.Bytes(), &msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := msg["id"]; ok {
+				return msg
+			}
+		}
+		t.Fatalf("%q: no response: %v", request, lines.Err())
+		return nil
+	}
+
+	resp := call(`{"jsonrpc": "2.0", "id": 1, "method": "getOutput"}`)
+	if text := resp["result"].(map[string]interface{})["text"]; !reflect.DeepEqual(text, []interface{}{"foo", "bar"}) {
+		t.Errorf("bad initial output: %v", resp)
+	}
+	call(`{"jsonrpc": "2.0", "id": 2, "method": "setInput", "params": {"text": "a\nb\nc\n"}}`)
+	call(`{"jsonrpc": "2.0", "id": 3, "method": "run", "params": {"command": "tr a-z A-Z"}}`)
+	for {
+		resp = call(`{"jsonrpc": "2.0", "id": 4, "method": "getOutput", "params": {"from": 1}}`)
+		result := resp["result"].(map[string]interface{})
+		if result["complete"] == true {
+			if !reflect.DeepEqual(result["text"], []interface{}{"B", "C"}) {
+				t.Errorf("bad output: %v", resp)
+			}
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	resp = call(`{"jsonrpc": "2.0", "id": 5, "method": "run", "params": {"command": "rm -rf /"}}`)
+	if resp["error"] == nil {
+		t.Errorf("dangerous command not refused: %v", resp)
+	}
+	resp = call(`{"jsonrpc": "2.0", "id": 6, "method": "noSuchMethod"}`)
+	if resp["error"].(map[string]interface{})["code"] != float64(rpcMethodNotFound) {
+		t.Errorf("bad error for unknown method: %v", resp)
+	}
+	// Notifications don't get responses, even if they fail
+	conn.Write([]byte(`{"jsonrpc": "2.0", "method": "noSuchMethod"}` + "\n"))
+	resp = call(`{"jsonrpc": "2.0", "id": 7, "method": "getState"}`)
+	if resp["id"] != float64(7) {
+		t.Errorf("response to notification: %v", resp)
+	}
+	resp = call(`{"jsonrpc": "2.0", "method": "getState"`)
+	if resp["id"] != nil || resp["error"].(map[string]interface{})["code"] != float64(rpcParseError) {
+		t.Errorf("bad error for malformed request: %v", resp)
+	}
+}
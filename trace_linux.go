@@ -0,0 +1,95 @@
+// Copyright 2018 The up AUTHORS
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux && (amd64 || arm64)
+// +build linux
+// +build amd64 arm64
+
+package main
+
+import (
+	"runtime"
+	"syscall"
+)
+
+// traceWrites attaches to the process with pid using ptrace, and calls write
+// with data written by the process to its standard output or error, until the
+// process exits. The result of attaching is sent to started. Only the main
+// thread of the process is traced.
+func traceWrites(pid int, started chan<- error, write func([]byte)) error {
+	// All ptrace requests must come from the thread which attached
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	err := syscall.PtraceAttach(pid)
+	if err == nil {
+		_, err = waitStopped(pid)
+	}
+	if err == nil {
+		err = syscall.PtraceSetOptions(pid, syscall.PTRACE_O_TRACESYSGOOD)
+	}
+	started <- err
+	if err != nil {
+		return nil
+	}
+
+	// Syscall stops happen in pairs: at entry to, and exit from a syscall
+	entry, signal := true, 0
+	for {
+		err := syscall.PtraceSyscall(pid, signal)
+		if err != nil {
+			return err
+		}
+		status, err := waitStopped(pid)
+		if err != nil || !status.Stopped() {
+			// Process exited
+			return err
+		}
+		signal = 0
+		if status.StopSignal() != syscall.SIGTRAP|0x80 {
+			// Deliver signals sent to the process
+			signal = int(status.StopSignal())
+			continue
+		}
+		if entry {
+			var regs syscall.PtraceRegs
+			err := syscall.PtraceGetRegs(pid, &regs)
+			if err != nil {
+				return err
+			}
+			nr, fd, addr, n := syscallArgs(&regs)
+			if nr == syscall.SYS_WRITE && (fd == 1 || fd == 2) && n > 0 {
+				const maxWrite = 1024 * 1024
+				if n > maxWrite {
+					n = maxWrite
+				}
+				data := make([]byte, n)
+				n, _ := syscall.PtracePeekData(pid, uintptr(addr), data)
+				write(data[:n])
+			}
+		}
+		entry = !entry
+	}
+}
+
+// waitStopped waits until the traced process stops or exits.
+func waitStopped(pid int) (syscall.WaitStatus, error) {
+	var status syscall.WaitStatus
+	for {
+		_, err := syscall.Wait4(pid, &status, syscall.WALL, nil)
+		if err != syscall.EINTR {
+			return status, err
+		}
+	}
+}
@@ -0,0 +1,23 @@
+// Copyright 2018 The up AUTHORS
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "syscall"
+
+// syscallArgs returns the number and first three arguments of a syscall, at
+// its entry.
+func syscallArgs(regs *syscall.PtraceRegs) (nr, arg0, arg1, arg2 uint64) {
+	return regs.Orig_rax, regs.Rdi, regs.Rsi, regs.Rdx
+}
@@ -22,18 +22,29 @@ import (
 	"bytes"
 	"context"
 	"crypto/sha1"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"math"
+	"net"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
 	"unicode"
 
-	"github.com/gdamore/tcell"
-	"github.com/gdamore/tcell/terminfo"
+	"github.com/gdamore/tcell/v2"
+	"github.com/gdamore/tcell/v2/terminfo"
 	"github.com/mattn/go-isatty"
 	"github.com/spf13/pflag"
 )
@@ -41,15 +52,12 @@ import (
 const version = "0.4 (2020-10-29)"
 
 // TODO: in case of error, show it in red (bg?), then below show again initial normal output (see also #4)
-// TODO: F1 should display help, and it should be multi-line, and scrolling licensing credits
 // TODO: some key shortcut to increase stdin capture buffer size (unless EOF already reached)
 // TODO: show status infos:
 //  - red fg + "up: process returned with error code %d" -- when subprocess returned an error
 //  - yellow fg -- when process is still not finished
 // TODO: on github: add issues, incl. up-for-grabs / help-wanted
 // TODO: [LATER] make it work on Windows; maybe with mattn/go-shellwords ?
-// TODO: [LATER] Ctrl-O shows input via `less` or $PAGER
-// TODO: properly show all licenses of dependencies on --version
 // TODO: [LATER] on ^X (?), leave TUI and run the command through buffered input, then unpause rest of input
 // TODO: [LATER] allow adding more elements of pipeline (initially, just writing `foo | bar` should work)
 // TODO: [LATER] allow invocation with partial command, like: `up grep -i` (see also #11)
@@ -57,12 +65,10 @@ const version = "0.4 (2020-10-29)"
 // TODO: [MUCH LATER] readline-like rich editing support? and completion? (see also #28)
 // TODO: [MUCH LATER] integration with fzf? and pindexis/marker?
 // TODO: [LATER] forking and unforking pipelines (see also #4)
-// TODO: [LATER] capture output of a running process (see: https://stackoverflow.com/q/19584825/98528)
 // TODO: [LATER] richer TUI:
 // - show # of read lines & kbytes
 // - show status (errorlevel) of process, or that it's still running (also with background colors)
 // - allow copying and pasting to/from command line
-// TODO: [LATER] allow connecting external editor (become server/engine via e.g. socket)
 // TODO: [LATER] become pluggable into http://luna-lang.org
 // TODO: [LATER][MAYBE] allow "plugins" ("combos" - commands with default options) e.g. for Lua `lua -e`+auto-quote, etc.
 // TODO: [LATER] make it more friendly to infrequent Linux users by providing "descriptive" commands like "search" etc.
@@ -73,7 +79,9 @@ const version = "0.4 (2020-10-29)"
 
 func init() {
 	pflag.Usage = func() {
-		fmt.Fprint(os.Stderr, `Usage: COMMAND | up [OPTIONS]
+		fmt.Fprint(os.Stderr, `Usage: COMMAND | up [OPTIONS] [| CONSUMER]
+   or: up [OPTIONS] FILE... [| CONSUMER]
+   or: up [OPTIONS] -- COMMAND [ARGS...] [| CONSUMER]
 
 up is the Ultimate Plumber, a tool for writing Linux pipes in a terminal-based
 UI interactively, with instant live preview of command results.
@@ -88,6 +96,37 @@ screen can be edited in order to interactively build a pipeline. Every time you
 hit [Enter], the bottom of the screen will display the results of passing the
 up's standard input through the pipeline (executed using your default $SHELL).
 
+Alternatively, up can run the input-producing command by itself, if it is
+given after '--' (or as a shell command in --input-cmd option), for example:
+
+    $ up -- kubectl get pods
+
+This allows re-running the command with Ctrl-R, to refresh the input data
+without leaving up. With --every option, the command is also re-run
+periodically, and lines of output which changed are highlighted, like in
+'watch -d':
+
+    $ up --every 2s -- ss -tn
+
+Input can also be read from files given as arguments (or matching quoted glob
+patterns, like '*.log.gz'), which are decompressed if needed (gzip, bzip2, or
+zstd with the zstd tool installed). Contents of all the files are concatenated
+as the input, but their names are also available to the pipeline in $UP_FILE1,
+$UP_FILE2, etc. variables (compressed files can be read with e.g. zcat -f),
+for example:
+
+    $ up old.txt new.txt
+    | comm -13 "$UP_FILE1" "$UP_FILE2"
+
+With --watch option, up reloads the files and re-runs the pipeline whenever
+they are changed, replaced or rotated.
+
+On Linux, up can also capture the output of an already running process, which
+was started without piping it anywhere, with --attach PID option. If the output
+of the process is redirected to a file, the file is followed, otherwise writes
+of the process are intercepted with ptrace (which may require root privileges,
+depending on /proc/sys/kernel/yama/ptrace_scope).
+
 If a tilde '~' is visible in top-left corner, it indicates that Ultimate
 Plumber did not yet fully consume its input. Some pipelines may not finish with
 incomplete input; use Ctrl-S to freeze reading the input and to inject fake
@@ -96,6 +135,76 @@ EOF; use Ctrl-Q to unfreeze back and continue reading.
 If a plus '+' is visible in top-left corner, the internal buffer limit
 (default: 40MB) was reached and Ultimate Plumber won't read more input.
 
+Before running a pipeline containing a potentially destructive command (like
+rm or dd; see --deny and --allow options), or an output redirection which may
+overwrite a file (like '> file' or 'tee file'), Ultimate Plumber asks for
+confirmation (see --guard option). Commands run by other commands, like with
+sh -c, eval, sudo, xargs or find -exec, are checked too, and so is deleting
+files with find -delete. Such pipelines are never run automatically
+in --unsafe-full-throttle mode, which is indicated by a distinct color of the
+pipeline command line.
+
+BATCH MODE
+
+With --batch option, up doesn't start the interactive interface, but runs the
+pipeline given with -c over its input (limited by --buf, like in interactive
+mode), and prints the output. As nobody can confirm running a dangerous
+command in this mode, such pipelines are refused (unless --guard is off). The
+pipeline can also be loaded from a script saved with Ctrl-X, using --run
+option (with pipefail option of the shell, if the script sets it). This allows
+testing saved pipelines in scripts and CI:
+
+    $ ./producer | up --run up1.sh | ./consumer
+
+SERVER MODE
+
+With --serve option, up doesn't start the interactive interface, but listens on
+a unix socket for JSON-RPC 2.0 requests, each sent as a single line of JSON
+text. This allows integrating up with editors and other tools. Supported
+methods are:
+
+- setInput {"text": TEXT} - replace the input data (by default, the input is
+  read like in interactive mode, or empty if stdin is a terminal)
+- setPipeline {"command": COMMAND} - set the pipeline command
+- run {"command": COMMAND, "confirm": BOOL} - run the pipeline (optionally
+  setting it first); dangerous commands are run only if confirmed (see --guard)
+- cancel - kill the running pipeline
+- getState - return the state of output: {"run": N, "command": COMMAND,
+  "lines": N, "complete": BOOL}, where run is increased with each run
+- getOutput {"from": N, "to": N} - return the state of output, and lines of
+  output from line number 'from' (starting at 0, default) until 'to'
+  (excluding it; all lines by default), as {..., "from": N, "text": [LINES]}
+
+When output of the pipeline grows, outputChanged notification is sent to all
+clients, with the state of output as params.
+
+REMOTE CONTROL
+
+With --listen option, the interactive interface can be controlled by other
+tools, like scripts sending text from an editor, via JSON-RPC 2.0 requests on
+a unix socket (sent like in server mode). Supported methods are:
+
+- getState - return the state of the interface: {"command": COMMAND,
+  "current": BOOL, "lines": N, "complete": BOOL}, where current tells if the
+  shown output is of the command in the editor
+- setCommand {"command": COMMAND, "run": BOOL} - replace the text of the
+  pipeline command in the editor, and optionally run it
+- run - run the pipeline command, like when Enter is pressed (dangerous
+  commands still need to be confirmed in the interface)
+- getOutput {"from": N, "to": N} - return the state of the interface, and
+  lines of the shown output, like in server mode
+
+WEB PAGE
+
+With --http option, up also shows the pipeline and its output on a web page
+(e.g. --http 127.0.0.1:8080), which is updated live. This allows viewing wide
+tables in a browser, or sharing a session on a screen. The pipeline can be
+edited on the page too, so the page should not be made available to other
+people than you: it's served only on an address on local host (unless allowed
+with --http-remote), and only to requests with a random token generated for
+the session. Open the link shown at the bottom of the screen, or on the help
+screen, which has it.
+
 KEYS
 
 - alphanumeric & symbol keys, Left, Right, Ctrl-A/E/B/F/K/Y/W
@@ -104,14 +213,46 @@ KEYS
 - Up, Dn, PgUp, PgDn, Ctrl-Left, Ctrl-Right
                       - navigate (scroll) the pipeline output panel
 - Ctrl-X  - exit and write the pipeline to up1.sh (or if it exists then to
-            up2.sh, etc. till up1000.sh)
+            up2.sh, etc. till up1000.sh); when asked, type f, a, m or j
+            instead of Enter to append the pipeline to a chosen file as a
+            shell function, alias, Makefile target or justfile recipe
+            (the last two only for single-line pipelines, as Make and just
+            run each line in a separate shell)
 - Ctrl-C  - quit without saving and emit the pipeline on standard output
+- Ctrl-P  - quit and emit the full output of the pipeline on standard output
+            (running it again over complete input, including any input not
+            yet read); this allows using up in the middle of a pipeline, like:
+            $ producer | up | consumer
+- F2      - save the pipeline output to a file (if the pipeline is still
+            running, the file is written when its output is complete)
+- F3, Ctrl-O
+          - view the currently displayed output in $PAGER (default: less)
+- F4      - open the currently displayed output in $VISUAL or $EDITOR
+- Ctrl-V  - edit the pipeline command in $VISUAL or $EDITOR, then run it
+- F1      - show help, including all key bindings and licenses
 - Ctrl-S  - temporarily freeze a long-running input to Ultimate Plumber,
             injecting a fake EOF into the buffer (shows '#' indicator in
             top-left corner)
 - Ctrl-Q  - unfreeze back after Ctrl-S (disables '#' indicator)
+- Ctrl-R  - re-run the input command given after '--' or in --input-cmd,
+            refreshing the input data
+
+Above keys can be changed in [keys] section of the configuration file (see
+below), by listing new keys for any action shown on the F1 help screen, e.g.:
+
+    [keys]
+    pause-input = ["F7"]
+    resume-input = ["F8"]
 
 OPTIONS
+
+Defaults for all options can be provided in a configuration file, with lines
+like below (options given on command line override the ones from the file,
+and both override $NO_COLOR):
+
+    unsafe-full-throttle = true
+    exec = ["bash", "-c"]
+
 `)
 		pflag.PrintDefaults()
 		fmt.Fprint(os.Stderr, `
@@ -124,20 +265,96 @@ VERSION: `+version+`
 
 var (
 	// TODO: dangerous? immediate? raw? unsafe? ...
-	// FIXME(akavel): mark the unsafe mode vs. safe mode with some colour or status; also inform/mark what command's results are displayed...
-	unsafeMode   = pflag.Bool("unsafe-full-throttle", false, "enable mode in which pipeline is executed immediately after any change (without pressing Enter)")
-	outputScript = pflag.StringP("output-script", "o", "", "save the command to specified `file` if Ctrl-X is pressed (default: up<N>.sh)")
-	debugMode    = pflag.Bool("debug", false, "debug mode")
-	noColors     = pflag.Bool("no-colors", false, "disable interface colors")
-	shellFlag    = pflag.StringArrayP("exec", "e", nil, "`command` to run pipeline with; repeat multiple times to pass multi-word command; defaults to '-e=$SHELL -e=-c'")
-	initialCmd   = pflag.StringP("pipeline", "c", "", "initial `commands` to use as pipeline (default empty)")
-	bufsize      = pflag.Int("buf", 40, "input buffer size & pipeline buffer sizes in `megabytes` (MiB)")
-	noinput      = pflag.Bool("noinput", false, "start with empty buffer regardless if any input was provided")
+	unsafeMode     = pflag.Bool("unsafe-full-throttle", false, "enable mode in which pipeline is executed immediately after any change (without pressing Enter)")
+	debounce       = pflag.Duration("debounce", 0, "in --unsafe-full-throttle mode, run the pipeline only after typing pauses for this `duration` (e.g. 300ms)")
+	skipIncomplete = pflag.Bool("skip-incomplete", false, "in --unsafe-full-throttle mode, don't run commands which look unfinished (e.g. with unbalanced quotes, or ending with '|')")
+	guardMode      = pflag.String("guard", "confirm", "what to do when pipeline contains a dangerous command (see --deny and --allow): 'confirm' asks before running it, 'refuse' never runs it, 'off' disables the check")
+	denyCommands   = pflag.StringSlice("deny", []string{"rm", "rmdir", "shred", "dd", "truncate", "mkfs*", "wipefs", "fdisk", "parted", ">"}, "comma-separated `patterns` of names of dangerous commands, which will not run without confirmation (see --guard); '>' stands for output redirections overwriting files")
+	allowCommands  = pflag.StringSlice("allow", nil, "comma-separated `patterns` of names of commands allowed to run; if set, any other command is considered dangerous (see --guard)")
+	sandboxMode    = pflag.Bool("sandbox", false, "run pipelines in a sandbox (Linux only): with no network access nor unix sockets, no access to other processes, and all files read-only except for an empty /tmp")
+	timeout        = pflag.Duration("timeout", 0, "kill the pipeline if it runs longer than `duration` (e.g. 30s)")
+	cpuLimit       = pflag.Duration("cpu-limit", 0, "limit CPU time of each process in the pipeline to `duration` (e.g. 10s)")
+	memLimit       = pflag.Int("mem-limit", 0, "limit virtual memory of each process in the pipeline to `megabytes` (MiB)")
+	batchMode      = pflag.Bool("batch", false, "don't start the interactive interface, but run the pipeline given with -c over the input, and print its output")
+	runScript      = pflag.String("run", "", "run the pipeline saved in a `file` with Ctrl-X (like up1.sh) in --batch mode")
+	httpAddr       = pflag.String("http", "", "also show the pipeline and its output on a web page served at `address` (e.g. 127.0.0.1:8080), where the pipeline can be edited too")
+	httpRemote     = pflag.Bool("http-remote", false, "allow serving the --http web page on an address which is not on local host (DANGEROUS: anyone with the link can run commands)")
+	serveSocket    = pflag.String("serve", "", "don't start the interactive interface, but serve JSON-RPC requests on unix `socket` (see SERVER MODE)")
+	listenSocket   = pflag.String("listen", "", "allow controlling the interactive interface with JSON-RPC requests on unix `socket` (see REMOTE CONTROL)")
+	outputScript   = pflag.StringP("output-script", "o", "", "save the command to specified `file` if Ctrl-X is pressed (default: up<N>.sh)")
+	debugMode      = pflag.Bool("debug", false, "debug mode")
+	noColors       = pflag.Bool("no-colors", false, "disable interface colors (also enabled by non-empty $NO_COLOR, unless --theme or --no-colors is set on command line or in config file)")
+	themeName      = pflag.String("theme", "dark", "`name` of color theme of the interface: "+strings.Join(themeNames(), ", "))
+	shellFlag      = pflag.StringArrayP("exec", "e", nil, "`command` to run pipeline with; repeat multiple times to pass multi-word command; defaults to '-e=$SHELL -e=-c'")
+	initialCmd     = pflag.StringP("pipeline", "c", "", "initial `commands` to use as pipeline (default empty)")
+	bufsize        = pflag.Int("buf", 40, "input buffer size & pipeline buffer sizes in `megabytes` (MiB)")
+	noinput        = pflag.Bool("noinput", false, "start with empty buffer regardless if any input was provided")
+	everyInterval  = pflag.Duration("every", 0, "re-run the input command every `duration` (e.g. 2s), highlighting lines of output which changed")
+	watchMode      = pflag.Bool("watch", false, "reload input files and re-run the pipeline whenever the files change")
+	attachPID      = pflag.Int("attach", 0, "capture output of an already running process with `pid` as input, instead of standard input (Linux only)")
+	inputCmd       = pflag.String("input-cmd", "", "run `command` with the shell (see --exec) and use its output as input, instead of standard input; same as: up -- $SHELL -c command")
+	configPath     = pflag.String("config", "", "read defaults for options from TOML `file` (default: $XDG_CONFIG_HOME/up/config.toml)")
+	showVersion    = pflag.Bool("version", false, "print version of up, and versions & licenses of its dependencies, then exit")
+	showLicenses   = pflag.Bool("licenses", false, "print full texts of licenses of up and its dependencies, then exit")
+	emitOutput     = pflag.Bool("emit-output", false, "on Ctrl-X, emit the full output of the pipeline on standard output instead of saving the command to a file")
 )
 
+// killGracePeriod is how long processes of a killed pipeline have to exit
+// after SIGTERM, before they get SIGKILL.
+const killGracePeriod = 2 * time.Second
+
+// helperEnv is set in environment of up, when it is re-executed as a helper
+// which prepares environment for the pipeline (see prepareCommand).
+const helperEnv = "UP_HELPER"
+
 func main() {
+	// up may be re-executed by itself, to prepare environment for the pipeline
+	if spec := os.Getenv(helperEnv); spec != "" {
+		runHelper(spec, os.Args[1:])
+	}
+
 	// Handle command-line flags
 	pflag.Parse()
+	if *showVersion || *showLicenses {
+		printVersion(os.Stdout, *showLicenses)
+		return
+	}
+
+	// Read defaults for flags not provided on command line from config file
+	path, mustExist := *configPath, true
+	if path == "" {
+		path, mustExist = defaultConfigPath(), false
+	}
+	cfg, err := loadConfig(path, mustExist)
+	if err == nil {
+		err = cfg.checkSections("keys")
+	}
+	if err == nil {
+		err = cfg.applyFlags(pflag.CommandLine)
+	}
+	if err == nil {
+		err = applyKeys(cfg["keys"])
+	}
+	if err != nil {
+		die(err.Error())
+	}
+
+	switch *guardMode {
+	case "confirm", "refuse", "off":
+	default:
+		die("invalid --guard mode: " + *guardMode + "; expected one of: confirm, refuse, off")
+	}
+
+	// Choose interface colors; see also: https://no-color.org/ Explicit
+	// settings, on command line or in config file, override $NO_COLOR
+	if os.Getenv("NO_COLOR") != "" && !pflag.CommandLine.Changed("theme") && !pflag.CommandLine.Changed("no-colors") {
+		*noColors = true
+	}
+	if t, ok := themes[*themeName]; ok {
+		theme = t
+	} else {
+		die("unknown theme: " + *themeName + "; available themes: " + strings.Join(themeNames(), ", "))
+	}
 
 	log.SetOutput(ioutil.Discard)
 	if *debugMode {
@@ -173,15 +390,123 @@ func main() {
 	}
 	log.Println("found shell:", shell)
 
-	stdin := io.Reader(os.Stdin)
-	if *noinput {
-		stdin = bytes.NewReader(nil)
+	// Input data may be read from files, or produced by a command run by up,
+	// instead of being piped
+	files, producer := pflag.Args(), []string(nil)
+	if dash := pflag.CommandLine.ArgsLenAtDash(); dash != -1 {
+		files, producer = files[:dash], files[dash:]
+		if len(producer) == 0 {
+			die("missing input command after '--'")
+		}
+	}
+	if len(files) > 0 && (producer != nil || *inputCmd != "") {
+		die("cannot use both input files and an input command")
+	}
+	if *watchMode && len(files) == 0 {
+		die("--watch requires input files")
+	}
+	if *inputCmd != "" {
+		if producer != nil {
+			die("cannot use both --input-cmd and an input command after '--'")
+		}
+		producer = append(append([]string{}, shell...), *inputCmd)
+	}
+	if *everyInterval < 0 || *everyInterval > 0 && producer == nil {
+		die("--every requires an input command")
+	}
+	if *attachPID != 0 && (producer != nil || len(files) > 0) {
+		die("cannot use --attach together with input files or an input command")
+	}
+
+	// inputSource describes where input data come from, for saved scripts
+	stdin, inputSource := io.Reader(os.Stdin), "standard input"
+	paths, inputFiles := []string(nil), io.ReadCloser(nil)
+	if producer != nil {
+		stdin, inputSource = nil, "command: "+*inputCmd
+		if *inputCmd == "" {
+			inputSource = "command: " + shellJoin(producer)
+		}
+	} else if len(files) > 0 {
+		paths, err = expandFiles(files)
+		if err != nil {
+			die(err.Error())
+		}
+		setFileVars(paths)
+		inputFiles = readFiles(paths)
+		stdin, inputSource = inputFiles, "files: "+shellJoin(paths)
+	} else if *attachPID != 0 {
+		stdin, err = attachProcess(*attachPID)
+		if err != nil {
+			die(err.Error())
+		}
+		inputSource = fmt.Sprintf("output of process %d", *attachPID)
+	} else if *noinput {
+		stdin, inputSource = bytes.NewReader(nil), "none"
+	} else if *serveSocket != "" && isatty.IsTerminal(os.Stdin.Fd()) {
+		// In server mode, input may be provided later by a client
+		stdin, inputSource = bytes.NewReader(nil), "none"
 	} else if isatty.IsTerminal(os.Stdin.Fd()) {
 		// TODO: Without this block, we'd hang when nothing is piped on input (see
 		// github.com/peco/peco, mattn/gof, fzf, etc.)
 		die("up requires some data piped on standard input, for example try: `echo hello world | up`")
 	}
 
+	// In batch mode, run the pipeline non-interactively, like it'd be run in
+	// the interactive interface
+	if *runScript != "" {
+		if *initialCmd != "" {
+			die("cannot use both --run and -c")
+		}
+		text, err := ioutil.ReadFile(*runScript)
+		if err != nil {
+			die(err.Error())
+		}
+		command, pipefail := scriptCommand(string(text))
+		if pipefail {
+			// Fail like the script would, if any command in the pipeline failed
+			command = "set -o pipefail\n" + command
+		}
+		*initialCmd, *batchMode = command, true
+	}
+	// Both in batch mode and in server mode, input is read into a buffer, like
+	// in the interactive interface
+	headlessInput := func() *Buf {
+		if *watchMode || *everyInterval > 0 {
+			die("cannot use --watch nor --every in --batch nor --serve mode")
+		}
+		if producer != nil {
+			return StartProducer(producer, *bufsize*1024*1024, func() {}).Buf
+		}
+		return NewBuf(*bufsize*1024*1024).StartCapturing(stdin, func() {})
+	}
+	if *batchMode {
+		// Nobody can confirm running a dangerous command here
+		if name := forbiddenCommand(*initialCmd, *denyCommands, *allowCommands); name != "" && *guardMode != "off" {
+			die("refusing to run: " + name + " (see --guard, --deny and --allow options)")
+		}
+		runAndExit(shell, *initialCmd, headlessInput().NewReader(true))
+	}
+	if *serveSocket != "" {
+		err := serve(*serveSocket, shell, headlessInput())
+		if err != nil {
+			die(err.Error())
+		}
+		return
+	}
+
+	// Web UI is started later, but the address is checked before the terminal
+	// is taken over, so that errors can be reported
+	var webListener net.Listener
+	if *httpAddr != "" {
+		if !loopbackAddress(*httpAddr) && !*httpRemote {
+			die("refusing to serve the web page on " + *httpAddr + ", which is not an address on local host (see --http-remote option)")
+		}
+		webListener, err = net.Listen("tcp", *httpAddr)
+		if err != nil {
+			die(err.Error())
+		}
+	}
+
 	// Initialize TUI infrastructure
 	tui := initTUI()
 	defer tui.Fini()
@@ -194,61 +519,358 @@ func main() {
 		// The rest of the screen is a view of the results of the command
 		commandOutput = BufView{}
 		// Sometimes, a message may be displayed at the bottom of the screen, with help or other info
-		message = `Enter runs  ^X exit (^C nosave)  PgUp/PgDn/Up/Dn/^</^> scroll  ^S pause (^Q end)  [Ultimate Plumber v` + version + ` by akavel et al.]`
+		message      = hintText() + `  [Ultimate Plumber v` + version + ` by akavel et al.]`
+		messageStyle = theme.Message
+		// Sometimes, instead of the message, user is asked a question at the bottom of the screen
+		prompt *Prompt = nil
+		// When user asks for help, it is shown over the whole screen, until closed
+		helpView *BufView = nil
 	)
 
 	// Initialize main data flow
 	var (
-		// We capture data piped to 'up' on standard input into an internal buffer
+		// We capture data piped to 'up' on standard input (or output of the
+		// input command) into an internal buffer.
 		// When some new data shows up on stdin, we raise a custom signal,
 		// so that main loop will refresh the buffers and the output.
-		stdinCapture = NewBuf(*bufsize*1024*1024).
-				StartCapturing(stdin, func() { triggerRefresh(tui) })
+		stdinCapture *Buf = nil
+		// If input command was provided, this is the process running it
+		inputProducer *Subprocess = nil
+		// With --every, the input command is periodically re-run in background;
+		// until it finishes, the previous input is still used
+		pendingInput *Subprocess = nil
 		// Then, we pass this data as input to a subprocess.
 		// Initially, no subprocess is running, as no command is entered yet
 		commandSubprocess *Subprocess = nil
 	)
+	if producer != nil {
+		inputProducer = StartProducer(producer, *bufsize*1024*1024, func() { triggerRefresh(tui) })
+		stdinCapture = inputProducer.Buf
+	} else {
+		stdinCapture = NewBuf(*bufsize*1024*1024).
+			StartCapturing(stdin, func() { triggerRefresh(tui) })
+	}
 	// Intially, for user's convenience, show the raw input data, as if `cat` command was typed
 	commandOutput.Buf = stdinCapture
+	// exitSignal is the signal which made us quit, if any; up then exits like
+	// killed by it, after the cleanup below
+	var exitSignal syscall.Signal
+	defer func() {
+		if exitSignal != 0 {
+			tui.Fini()
+			os.Exit(128 + int(exitSignal))
+		}
+	}()
+	// Don't leave the pipeline running in background after we quit
+	defer func() {
+		commandSubprocess.Kill()
+		inputProducer.Kill()
+		pendingInput.Kill()
+		commandSubprocess.Wait()
+		inputProducer.Wait()
+		pendingInput.Wait()
+	}()
+	// Quit when the terminal is closed, or we are asked to stop; pipelines are
+	// run in their own process groups, so they don't get the signals from the
+	// terminal, and must be killed by us
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-signals
+		tui.PostEventWait(tcell.NewEventInterrupt(sig))
+	}()
+
+	// guarded is the last command which was stopped from running by the guard,
+	// and confirmed is the last one which user allowed to run nevertheless
+	guarded, confirmed := "", ""
+	// dangerous returns the name of a dangerous command in the pipeline, if any
+	dangerous := func(command string) string {
+		if *guardMode == "off" || command == confirmed {
+			return ""
+		}
+		return forbiddenCommand(command, *denyCommands, *allowCommands)
+	}
+	// confirmRun asks user for permission to run a dangerous command
+	confirmRun := func(name string, run func()) {
+		if *guardMode == "refuse" {
+			message, messageStyle = "up: refusing to run: "+name+" (see --guard, --deny and --allow options)", theme.Error
+			return
+		}
+		prompt = NewPrompt("up: really run: "+name+"? [y/N] ", "", func(answer string) {
+			if isYes(answer) {
+				run()
+			}
+		})
+	}
+
+	// quit is set by answers to prompts which end the session
+	quit := false
+	// remote serves requests sent with --listen option, if any
+	var remote *rpcServer
+
+	// emit quits and emits output of the pipeline on standard output
+	emit := func(command string) {
+		run := func() {
+			tui.Fini()
+			remote.Close()
+			emitAndExit(shell, command, commandSubprocess, stdinCapture, inputProducer, pendingInput)
+		}
+		if name := dangerous(command); name != "" {
+			confirmRun(name, run)
+			return
+		}
+		run()
+	}
+
+	// startPipeline kills the running pipeline, and starts the command over
+	// the input data instead
+	startPipeline := func(command string) {
+		commandSubprocess.Kill()
+		if command != "" {
+			commandSubprocess = StartSubprocess(shell, command, stdinCapture, func() { triggerRefresh(tui) })
+			commandOutput.Buf = commandSubprocess.Buf
+		} else {
+			// If command is empty, show original input data again (~ equivalent of typing `cat`)
+			commandSubprocess = nil
+			commandOutput.Buf = stdinCapture
+		}
+	}
 
 	// Main loop
 	lastCommand := ""
+	lastEdited, editTime := "", time.Now()
 	restart := false
+	reported, reportedInput := (*Subprocess)(nil), (*Subprocess)(nil)
+
+	// In watch mode, reload the input files and re-run the pipeline whenever
+	// they change
+	if *watchMode {
+		err := watchFiles(paths, func() {
+			runInMainLoop(tui, func() {
+				inputFiles.Close()
+				inputFiles = readFiles(paths)
+				stdinCapture = NewBuf(*bufsize*1024*1024).
+					StartCapturing(inputFiles, func() { triggerRefresh(tui) })
+				restart = true
+			})
+		})
+		if err != nil {
+			message, messageStyle = "up: cannot watch input files: "+err.Error(), theme.Error
+		}
+	}
+
+	// Mirror the interface on a web page, if requested. Edits of the command
+	// on the page are applied like if user typed them.
+	var web *webUI
+	if webListener != nil {
+		web, err = startWebUI(webListener, *httpRemote, func(command string, run bool) {
+			runInMainLoop(tui, func() {
+				commandEditor.Set(command)
+				restart = restart || run
+			})
+		})
+		if err != nil {
+			tui.Fini()
+			die(err.Error())
+		}
+		message, messageStyle = "up: web page at "+web.URL(), theme.Message
+	}
+
+	// With --listen, let other tools edit and run the command, and read the
+	// output. The requests are handled in the main loop, like key presses.
+	if *listenSocket != "" {
+		state := func() remoteState {
+			return remoteState{
+				Command:  commandEditor.String(),
+				Current:  commandEditor.String() == lastCommand,
+				Lines:    countLines(commandOutput.Buf),
+				Complete: commandOutput.Buf.Complete(),
+			}
+		}
+		handle := func(method string, params json.RawMessage) (interface{}, error) {
+			switch method {
+			case "getState":
+				return state(), nil
+			case "setCommand":
+				var p struct {
+					Command string
+					Run     bool
+				}
+				if err := parseParams(params, &p); err != nil {
+					return nil, err
+				}
+				commandEditor.Set(p.Command)
+				restart = restart || p.Run
+				return nil, nil
+			case "run":
+				restart = true
+				return nil, nil
+			case "getOutput":
+				lines, err := getLines(commandOutput.Buf, params)
+				if err != nil {
+					return nil, err
+				}
+				return struct {
+					remoteState
+					outputLines
+				}{state(), lines}, nil
+			}
+			return nil, &rpcError{Code: rpcMethodNotFound, Message: "unknown method: " + method}
+		}
+		remote, err = listenRPC(*listenSocket, func(method string, params json.RawMessage) (result interface{}, err error) {
+			done := make(chan struct{})
+			runInMainLoop(tui, func() {
+				result, err = handle(method, params)
+				close(done)
+			})
+			<-done
+			return result, err
+		})
+		if err != nil {
+			tui.Fini()
+			die(err.Error())
+		}
+		defer remote.Close()
+	}
+
+	// With --every, periodically re-run the input command in background
+	if *everyInterval > 0 {
+		go func() {
+			for range time.Tick(*everyInterval) {
+				runInMainLoop(tui, func() {
+					if pendingInput == nil {
+						pendingInput = StartProducer(producer, *bufsize*1024*1024, func() { triggerRefresh(tui) })
+					}
+				})
+			}
+		}()
+	}
 	for {
 		// If user edited the command, immediately run it in background, and
-		// kill the previously running command.
+		// kill the previously running command. If requested, wait until user
+		// stops typing, and skip commands which are obviously not finished.
 		command := commandEditor.String()
-		if restart || (*unsafeMode && command != lastCommand) {
-			commandSubprocess.Kill()
-			if command != "" {
-				commandSubprocess = StartSubprocess(shell, command, stdinCapture, func() { triggerRefresh(tui) })
-				commandOutput.Buf = commandSubprocess.Buf
+		if command != lastEdited {
+			lastEdited, editTime = command, time.Now()
+			if *unsafeMode && *debounce > 0 {
+				time.AfterFunc(*debounce, func() { triggerRefresh(tui) })
+			}
+		}
+		live := *unsafeMode && command != lastCommand && command != guarded &&
+			time.Since(editTime) >= *debounce &&
+			!(*skipIncomplete && incompleteCommand(command))
+		// Don't run potentially destructive commands without user's consent
+		if name := dangerous(command); (restart || live) && name != "" {
+			guarded = command
+			if restart {
+				cmd := command
+				confirmRun(name, func() {
+					confirmed = cmd
+					restart = true
+				})
+			} else if *guardMode == "refuse" {
+				message, messageStyle = "up: refusing to run: "+name+" (see --guard, --deny and --allow options)", theme.Error
 			} else {
-				// If command is empty, show original input data again (~ equivalent of typing `cat`)
-				commandSubprocess = nil
-				commandOutput.Buf = stdinCapture
+				message, messageStyle = "up: not running live: "+name+" (press Enter to confirm)", theme.Error
 			}
+			restart, live = false, false
+		}
+		if restart || live {
+			startPipeline(command)
+			commandOutput.Prev = nil
 			restart = false
 			lastCommand = command
 		}
+		// When the input command re-run by --every is finished, switch to its
+		// output, and show the changes in output of the last run pipeline
+		if pendingInput != nil && pendingInput.Buf.Complete() {
+			inputProducer.Kill()
+			inputProducer, pendingInput = pendingInput, nil
+			stdinCapture = inputProducer.Buf
+			prev := commandOutput.Buf.Lines()
+			startPipeline(lastCommand)
+			commandOutput.Prev = prev
+		}
+
+		// Tell user if the pipeline was killed because of limits set by them
+		if limit := commandSubprocess.ExceededLimit(); limit != "" && commandSubprocess != reported {
+			message, messageStyle = "up: pipeline killed: "+limit, theme.Error
+			reported = commandSubprocess
+		}
+		if err := inputProducer.Err(); err != nil && inputProducer != reportedInput {
+			message, messageStyle = "up: input command failed: "+err.Error(), theme.Error
+			reportedInput = inputProducer
+		}
 
 		// Draw UI
 		w, h := tui.Size()
-		style := whiteOnBlue
-		if command == lastCommand {
-			style = whiteOnDBlue
+		style := theme.Edited
+		if command == lastCommand && *unsafeMode {
+			style = theme.Live
+		} else if command == lastCommand {
+			style = theme.Current
 		}
 		stdinCapture.DrawStatus(TuiRegion(tui, 0, 0, 1, 1), style)
 		commandEditor.DrawTo(TuiRegion(tui, 1, 0, w-1, 1), style,
 			func(x, y int) { tui.ShowCursor(x+1, 0) })
 		commandOutput.DrawTo(TuiRegion(tui, 0, 1, w, h-1))
-		drawText(TuiRegion(tui, 0, h-1, w, 1), whiteOnBlue, message)
+		if helpView != nil {
+			helpView.DrawTo(TuiRegion(tui, 0, 0, w, h-1))
+			tui.HideCursor()
+		}
+		if prompt != nil {
+			prompt.DrawTo(TuiRegion(tui, 0, h-1, w, 1), theme.Message,
+				func(x, y int) { tui.ShowCursor(x, h-1) })
+		} else {
+			drawText(TuiRegion(tui, 0, h-1, w, 1), messageStyle, message)
+		}
 		tui.Show()
+		web.Update(command, command == lastCommand, commandOutput.Buf)
 
 		// Handle UI events
 		switch ev := tui.PollEvent().(type) {
+		// Some background activity finished, and wants to update the UI
+		case *tcell.EventInterrupt:
+			if f, ok := ev.Data().(func()); ok {
+				f()
+			}
+			if sig, ok := ev.Data().(syscall.Signal); ok {
+				exitSignal = sig
+				return
+			}
 		// Key pressed
 		case *tcell.EventKey:
+			// Is a question being asked to the user?
+			if prompt != nil {
+				switch getKey(ev) {
+				case key(tcell.KeyEnter):
+					p := prompt
+					prompt = nil
+					p.Answer(p.String())
+					if quit {
+						return
+					}
+				case key(tcell.KeyEscape),
+					key(tcell.KeyCtrlC),
+					ctrlKey(tcell.KeyCtrlC):
+					prompt = nil
+				default:
+					prompt.HandleKey(ev)
+				}
+				continue
+			}
+			// Is help displayed? Then only allow scrolling it, or closing it
+			if helpView != nil {
+				if !helpView.HandleKey(ev, h-1) {
+					switch {
+					case getKey(ev) == key(tcell.KeyEscape),
+						globalKeys.action(getKey(ev)) == "help",
+						getKey(ev) == key(tcell.KeyRune) && ev.Rune() == 'q':
+						helpView = nil
+					}
+				}
+				continue
+			}
 			// Is it a command editor key?
 			if commandEditor.HandleKey(ev) {
 				message = ""
@@ -260,41 +882,185 @@ func main() {
 				continue
 			}
 			// Some other global key combinations
-			switch getKey(ev) {
-			case key(tcell.KeyEnter):
+			// TODO: Ctrl-_: ask for another character to trigger command-line option, like in `less`
+			switch globalKeys.action(getKey(ev)) {
+			case "run":
 				restart = true
-			case key(tcell.KeyCtrlUnderscore),
-				ctrlKey(tcell.KeyCtrlUnderscore):
-				// TODO: ask for another character to trigger command-line option, like in `less`
-
-			case key(tcell.KeyCtrlS),
-				ctrlKey(tcell.KeyCtrlS):
+			case "help":
+				helpView = &BufView{Buf: NewBufString(helpText(web))}
+				message = ""
+			case "pause-input":
 				stdinCapture.Pause(true)
 				triggerRefresh(tui)
-			case key(tcell.KeyCtrlQ),
-				ctrlKey(tcell.KeyCtrlQ):
+			case "resume-input":
 				stdinCapture.Pause(false)
 				restart = true
-			case key(tcell.KeyCtrlC),
-				ctrlKey(tcell.KeyCtrlC),
-				key(tcell.KeyCtrlD),
-				ctrlKey(tcell.KeyCtrlD):
-				// Quit
+			case "rerun-input":
+				if inputProducer == nil {
+					message, messageStyle = "up: no input command to re-run (see --input-cmd)", theme.Error
+					break
+				}
+				inputProducer.Kill()
+				pendingInput.Kill()
+				inputProducer, pendingInput = StartProducer(producer, *bufsize*1024*1024, func() { triggerRefresh(tui) }), nil
+				stdinCapture = inputProducer.Buf
+				restart = true
+			case "quit":
 				tui.Fini()
 				os.Stderr.WriteString("up: Ultimate Plumber v" + version + " https://github.com/akavel/up\n")
 				os.Stderr.WriteString("up: | " + commandEditor.String() + "\n")
 				return
-			case key(tcell.KeyCtrlX),
-				ctrlKey(tcell.KeyCtrlX):
-				// Write script 'upN.sh' and quit
-				tui.Fini()
-				writeScript(shell, commandEditor.String(), tui)
-				return
+			case "write-script":
+				if *emitOutput {
+					emit(command)
+					break
+				}
+				if *outputScript != "" {
+					tui.Fini()
+					writeScript(shell, commandEditor.String(), inputSource, tui)
+					return
+				}
+				// Ask how to save the pipeline: by default, write script
+				// 'upN.sh' and quit; or append its definition to a file
+				prompt = NewPrompt("Save as: [s]cript, "+exportChoices()+"? [s] ", "", func(answer string) {
+					if answer = strings.TrimSpace(answer); answer == "" || strings.EqualFold(answer, "s") {
+						tui.Fini()
+						writeScript(shell, command, inputSource, tui)
+						quit = true
+						return
+					}
+					format := findExportFormat(answer)
+					if format == nil {
+						message, messageStyle = "up: unknown format: "+answer, theme.Error
+						return
+					}
+					prompt = NewPrompt("Name of "+format.name+": ", "", func(name string) {
+						if name == "" {
+							return
+						}
+						prompt = NewPrompt("Append "+format.name+" "+name+" to file: ", format.file, func(path string) {
+							if path == "" {
+								return
+							}
+							err := exportPipeline(format, name, command, path)
+							if err != nil {
+								message, messageStyle = "up: exporting "+format.name+" failed: "+err.Error(), theme.Error
+								return
+							}
+							tui.Fini()
+							os.Stderr.WriteString("up: Ultimate Plumber v" + version + " https://github.com/akavel/up\n")
+							os.Stderr.WriteString("up: appended " + format.name + " " + name + " to " + path + " - OK\n")
+							quit = true
+						})
+					})
+				})
+			case "emit-output":
+				emit(command)
+			case "view-in-pager":
+				err := viewInPager(tui, commandOutput.Buf)
+				if err != nil {
+					message, messageStyle = "up: pager failed: "+err.Error(), theme.Error
+				}
+			case "view-in-editor":
+				err := viewInEditor(tui, commandOutput.Buf)
+				if err != nil {
+					message, messageStyle = "up: editor failed: "+err.Error(), theme.Error
+				}
+			case "edit-command":
+				// Edit the command in external editor, then run it
+				edited, err := editTempFile(tui, "up-*.sh", strings.NewReader(commandEditor.String()+"\n"))
+				if err != nil {
+					message, messageStyle = "up: editor failed: "+err.Error(), theme.Error
+					break
+				}
+				commandEditor.Set(strings.TrimRight(string(edited), "\n"))
+				restart = true
+			case "save-output":
+				// Ask for a file name, then save the currently displayed output there
+				buf := commandOutput.Buf
+				prompt = NewPrompt("Save output to file: ", "", func(path string) {
+					if path == "" {
+						return
+					}
+					save := func() {
+						message, messageStyle = "up: saving output to "+path+"...", theme.Message
+						saveOutput(buf, path, func(err error) {
+							runInMainLoop(tui, func() {
+								if err != nil {
+									message, messageStyle = "up: saving output to "+path+" failed: "+err.Error(), theme.Error
+								} else {
+									message, messageStyle = "up: output saved to "+path, theme.Message
+								}
+							})
+						})
+					}
+					if _, err := os.Stat(path); err == nil {
+						prompt = NewPrompt("File "+path+" exists. Overwrite? [y/N] ", "", func(answer string) {
+							if isYes(answer) {
+								save()
+							}
+						})
+						return
+					}
+					save()
+				})
 			}
 		}
 	}
 }
 
+// printVersion writes information about up's version and build, including
+// versions and licenses of all compiled-in modules. If withLicenses is set,
+// full texts of the licenses are printed too.
+func printVersion(w io.Writer, withLicenses bool) {
+	fmt.Fprintf(w, "up (Ultimate Plumber) v%s https://github.com/akavel/up\n", version)
+	fmt.Fprintf(w, "license: %s\n", licenseOf("github.com/akavel/up"))
+	fmt.Fprintf(w, "go: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	bi, ok := debug.ReadBuildInfo()
+	if ok {
+		settings := map[string]string{}
+		for _, s := range bi.Settings {
+			settings[s.Key] = s.Value
+		}
+		if rev := settings["vcs.revision"]; rev != "" {
+			if settings["vcs.modified"] == "true" {
+				rev += " (modified)"
+			}
+			fmt.Fprintf(w, "revision: %s\n", rev)
+		}
+		if t := settings["vcs.time"]; t != "" {
+			fmt.Fprintf(w, "revision time: %s\n", t)
+		}
+		fmt.Fprintf(w, "dependencies:\n")
+		for _, dep := range bi.Deps {
+			depPath, depVersion := dep.Path, dep.Version
+			if dep.Replace != nil {
+				depVersion += " => " + dep.Replace.Path + " " + dep.Replace.Version
+			}
+			fmt.Fprintf(w, "  %s %s (license: %s)\n", depPath, depVersion, licenseOf(depPath))
+		}
+	}
+	if withLicenses {
+		printLicenses(w)
+	}
+}
+
+func printLicenses(w io.Writer) {
+	for _, l := range licenses {
+		fmt.Fprintf(w, "\n----- %s (%s) -----\n\n%s", l.Module, l.SPDX, l.Text)
+	}
+}
+
+// licenseOf returns the short identifier of the license of a module.
+func licenseOf(module string) string {
+	for _, l := range licenses {
+		if l.Module == module {
+			return l.SPDX
+		}
+	}
+	return "unknown"
+}
+
 func initTUI() tcell.Screen {
 	// TODO: maybe try gocui or termbox?
 	tui, err := tcell.NewScreen()
@@ -321,20 +1087,388 @@ running "up" with:
 Good luck!`,
 			err, term, hash))
 	}
-	if err != nil {
-		die(err.Error())
+	if err != nil {
+		die(err.Error())
+	}
+	err = tui.Init()
+	if err != nil {
+		die(err.Error())
+	}
+	return tui
+}
+
+// runOnTerminal suspends the TUI and runs an interactive external program on
+// the user's terminal. When the program exits, the TUI is restored.
+func runOnTerminal(tui tcell.Screen, cmd *exec.Cmd) error {
+	// Standard streams of up may be redirected, so talk to the terminal directly
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer tty.Close()
+	if cmd.Stdin == nil {
+		cmd.Stdin = tty
+	}
+	cmd.Stdout = tty
+	cmd.Stderr = tty
+
+	err = tui.Suspend()
+	if err != nil {
+		return err
+	}
+	err = cmd.Run()
+	if err := tui.Resume(); err != nil {
+		die(err.Error())
+	}
+	tui.Sync()
+	return err
+}
+
+// viewInPager shows current contents of buf in user's $PAGER.
+func viewInPager(tui tcell.Screen, buf *Buf) error {
+	pager := os.Getenv("PAGER")
+	if pager == "" {
+		pager = "less"
+	}
+	cmd := exec.Command("/bin/sh", "-c", pager)
+	cmd.Stdin = buf.NewReader(false)
+	return runOnTerminal(tui, cmd)
+}
+
+// viewInEditor opens current contents of buf in user's $VISUAL or $EDITOR.
+func viewInEditor(tui tcell.Screen, buf *Buf) error {
+	_, err := editTempFile(tui, "up-output-*.txt", buf.NewReader(false))
+	return err
+}
+
+// editTempFile writes contents to a temporary file, and opens it in user's
+// $VISUAL or $EDITOR. When the editor exits, contents of the file are returned
+// and the file is removed.
+func editTempFile(tui tcell.Screen, pattern string, contents io.Reader) ([]byte, error) {
+	f, err := ioutil.TempFile("", pattern)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	_, err = io.Copy(f, contents)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	err = f.Close()
+	if err != nil {
+		return nil, err
+	}
+	err = runOnTerminal(tui, editorCommand(f.Name()))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(f.Name())
+}
+
+// editorCommand builds a command opening a file in user's preferred editor.
+func editorCommand(path string) *exec.Cmd {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	// $EDITOR may contain arguments, so let the shell split it
+	return exec.Command("/bin/sh", "-c", editor+` "$1"`, "up", path)
+}
+
+// incompleteCommand checks if command looks like it is still being typed:
+// if it has unclosed quotes or parentheses, or ends with a pipe operator or an
+// escaping backslash.
+func incompleteCommand(command string) bool {
+	var (
+		quote   rune // the quote we're inside of, if any
+		escape  bool // if previous character was an escaping backslash
+		comment bool // if we're inside a comment
+		word    bool // if previous character was part of a word
+		depth   int  // level of nesting in parentheses & braces
+	)
+	for _, ch := range command {
+		switch {
+		case comment:
+			comment = ch != '\n'
+		case escape:
+			escape = false
+		case ch == '\\' && quote != '\'':
+			escape = true
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '\'' || ch == '"' || ch == '`':
+			quote = ch
+		case ch == '#' && !word:
+			comment = true
+		case ch == '(' || ch == '{':
+			depth++
+		case (ch == ')' || ch == '}') && depth > 0:
+			depth--
+		}
+		word = !unicode.IsSpace(ch)
+	}
+	if quote != 0 || escape || depth > 0 {
+		return true
+	}
+	trimmed := strings.TrimRightFunc(command, unicode.IsSpace)
+	for _, op := range []string{"|", "|&", "&&"} {
+		if strings.HasSuffix(trimmed, op) {
+			return true
+		}
+	}
+	return false
+}
+
+// shellWrappers are commands which run other commands given as their arguments.
+var shellWrappers = map[string]bool{
+	"sudo": true, "doas": true, "env": true, "nice": true, "nohup": true,
+	"time": true, "timeout": true, "command": true, "exec": true, "xargs": true,
+	"stdbuf": true, "ionice": true, "watch": true, "parallel": true,
+}
+
+// shells are commands which run a pipeline given with -c option.
+var shells = map[string]bool{
+	"sh": true, "bash": true, "zsh": true, "dash": true, "ksh": true, "mksh": true,
+}
+
+// forbiddenCommand returns the name of the first command in the pipeline which
+// matches any of the deny patterns, or (if allow is not empty) doesn't match
+// any of the allow patterns. If all commands are ok, empty string is returned.
+// Patterns are matched against the base names of the commands, using syntax
+// of path.Match.
+func forbiddenCommand(command string, deny, allow []string) string {
+	stages, outputs := pipelineStages(command)
+	for _, words := range stages {
+		if len(words) > 0 && path.Base(words[0]) == "tee" {
+			outputs = append(outputs, teeOutputs(words[1:])...)
+		}
+	}
+	for _, out := range outputs {
+		switch out {
+		case "/dev/null", "/dev/stdout", "/dev/stderr", "/dev/tty":
+			continue
+		}
+		if forbiddenName(">", deny, allow) {
+			return "> " + out
+		}
+	}
+	for _, words := range stages {
+		if name := forbiddenWords(words, deny, allow); name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+func forbiddenName(name string, deny, allow []string) bool {
+	return len(allow) > 0 && !matchAny(allow, name) || matchAny(deny, name)
+}
+
+// forbiddenWords checks a simple command split into words, like
+// forbiddenCommand. Commands which it runs, like with `sh -c` or
+// `find -exec`, are checked too.
+func forbiddenWords(words []string, deny, allow []string) string {
+	// Skip variable assignments, like in: `LC_ALL=C sort`
+	for len(words) > 0 && strings.Contains(words[0], "=") {
+		words = words[1:]
+	}
+	if len(words) == 0 {
+		return ""
+	}
+	name := path.Base(words[0])
+	if forbiddenName(name, deny, allow) {
+		return name
+	}
+	switch {
+	case name == "eval":
+		return forbiddenCommand(strings.Join(words[1:], " "), deny, allow)
+	case shells[name]:
+		// The pipeline is the first argument after options, if they
+		// include -c (like in `bash -ec 'rm x'`)
+		withC := false
+		for i := 1; i < len(words); i++ {
+			w := words[i]
+			switch {
+			case w == "-o" || w == "+o" || w == "-O" || w == "+O":
+				i++
+			case strings.HasPrefix(w, "-") && !strings.HasPrefix(w, "--"):
+				withC = withC || strings.Contains(w, "c")
+			case strings.HasPrefix(w, "-") || strings.HasPrefix(w, "+"):
+			case withC:
+				return forbiddenCommand(w, deny, allow)
+			default:
+				return ""
+			}
+		}
+	case name == "find":
+		for i := 1; i < len(words); i++ {
+			switch words[i] {
+			case "-delete":
+				if forbiddenName("rm", deny, allow) {
+					return "find -delete"
+				}
+			case "-exec", "-execdir", "-ok", "-okdir":
+				// The command ends with `;` or `+`
+				end := i + 1
+				for end < len(words) && words[end] != ";" && words[end] != "+" {
+					end++
+				}
+				if name := forbiddenWords(words[i+1:end], deny, allow); name != "" {
+					return name
+				}
+				i = end
+			}
+		}
+	case shellWrappers[name]:
+		// Arguments of commands like `xargs` or `sudo` may be other commands;
+		// it's hard to say which argument exactly, so be conservative and check
+		// all of them (only with deny patterns, as most of them are not
+		// commands)
+		for i := 1; i < len(words); i++ {
+			if name := forbiddenWords(words[i:], deny, nil); name != "" {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// teeOutputs returns the files which would be overwritten by the tee command
+// with given arguments.
+func teeOutputs(args []string) []string {
+	var files []string
+	for i, arg := range args {
+		switch {
+		case arg == "-a" || arg == "--append" || strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--") && strings.Contains(arg, "a"):
+			return nil
+		case arg == "--":
+			return append(files, args[i+1:]...)
+		case !strings.HasPrefix(arg, "-") || arg == "-":
+			files = append(files, arg)
+		}
+	}
+	return files
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// pipelineStages splits a shell command into simple commands, each being a
+// list of words. Commands are separated by operators like | ; & && || and
+// also by parentheses, braces, and command substitutions. Targets of output
+// redirections which may overwrite a file (> and >|) are returned separately
+// as outputs. It's only a rough approximation of shell syntax, good enough to
+// find names of commands.
+func pipelineStages(command string) (stages [][]string, outputs []string) {
+	var (
+		words    []string
+		word     []rune
+		inWord   bool
+		quote    rune
+		escape   bool
+		redirect bool // is the next word a target of output redirection?
+	)
+	endWord := func() {
+		switch {
+		case inWord && redirect:
+			outputs = append(outputs, string(word))
+			redirect = false
+		case inWord:
+			words = append(words, string(word))
+		}
+		word, inWord = word[:0], false
+	}
+	endStage := func() {
+		endWord()
+		if len(words) > 0 {
+			stages = append(stages, words)
+		}
+		words, redirect = nil, false
 	}
-	err = tui.Init()
-	if err != nil {
-		die(err.Error())
+	runes := []rune(command)
+	for i := 0; i < len(runes); i++ {
+		ch := runes[i]
+		switch {
+		case escape:
+			escape = false
+			word, inWord = append(word, ch), true
+		case ch == '\\' && quote != '\'':
+			escape = true
+		case quote == '\'':
+			if ch == quote {
+				quote = 0
+			} else {
+				word = append(word, ch)
+			}
+		case ch == '`' || ch == '(' && len(word) > 0 && word[len(word)-1] == '$':
+			// Command substitution is a separate command, even inside double quotes
+			if ch == '(' {
+				word = word[:len(word)-1]
+			}
+			endStage()
+			quote = 0
+		case quote == '"':
+			if ch == quote {
+				quote = 0
+			} else {
+				word = append(word, ch)
+			}
+		case ch == '\'' || ch == '"':
+			quote, inWord = ch, true
+		case ch == '>':
+			endWord()
+			next := rune(0)
+			if i+1 < len(runes) {
+				next = runes[i+1]
+			}
+			switch next {
+			case '>', '&':
+				// Appending to a file, or duplicating a file descriptor
+				i++
+			case '|':
+				i++
+				redirect = true
+			default:
+				redirect = true
+			}
+		case strings.ContainsRune("|&;(){}\n", ch):
+			endStage()
+		case unicode.IsSpace(ch):
+			endWord()
+		default:
+			word, inWord = append(word, ch), true
+		}
 	}
-	return tui
+	endStage()
+	return stages, outputs
+}
+
+func isYes(answer string) bool {
+	return answer == "y" || answer == "Y" || answer == "yes"
 }
 
 func triggerRefresh(tui tcell.Screen) {
 	tui.PostEvent(tcell.NewEventInterrupt(nil))
 }
 
+// runInMainLoop schedules f to be called from the main loop, so that it can
+// safely modify the state of the UI. It is intended to be used from goroutines.
+func runInMainLoop(tui tcell.Screen, f func()) {
+	tui.PostEventWait(tcell.NewEventInterrupt(f))
+}
+
 func die(message string) {
 	os.Stderr.WriteString("error: " + message + "\n")
 	os.Exit(1)
@@ -362,12 +1496,22 @@ type Editor struct {
 
 func (e *Editor) String() string { return string(e.value) }
 
+// Set replaces the edited value, moving the cursor to its end.
+func (e *Editor) Set(value string) {
+	e.value = []rune(value)
+	e.cursor = len(e.value)
+}
+
 func (e *Editor) DrawTo(region Region, style tcell.Style, setcursor func(x, y int)) {
 	// Draw prompt & the edited value - use white letters on blue background
 	for i, ch := range e.prompt {
 		region.SetCell(i, 0, style, ch)
 	}
 	for i, ch := range e.value {
+		if ch == '\n' {
+			// Value may be multi-line after editing in external editor
+			ch = '↵'
+		}
 		region.SetCell(len(e.prompt)+i, 0, style, ch)
 	}
 
@@ -390,38 +1534,28 @@ func (e *Editor) HandleKey(ev *tcell.EventKey) bool {
 		return true
 	}
 	// Handle editing & movement keys
-	switch getKey(ev) {
-	case key(tcell.KeyBackspace), key(tcell.KeyBackspace2):
-		// See https://github.com/nsf/termbox-go/issues/145
+	switch editorKeys.action(getKey(ev)) {
+	case "backward-delete-char":
 		e.delete(-1)
-	case key(tcell.KeyDelete):
+	case "delete-char":
 		e.delete(0)
-	case key(tcell.KeyLeft),
-		key(tcell.KeyCtrlB),
-		ctrlKey(tcell.KeyCtrlB):
+	case "backward-char":
 		if e.cursor > 0 {
 			e.cursor--
 		}
-	case key(tcell.KeyRight),
-		key(tcell.KeyCtrlF),
-		ctrlKey(tcell.KeyCtrlF):
+	case "forward-char":
 		if e.cursor < len(e.value) {
 			e.cursor++
 		}
-	case key(tcell.KeyCtrlA),
-		ctrlKey(tcell.KeyCtrlA):
+	case "beginning-of-line":
 		e.cursor = 0
-	case key(tcell.KeyCtrlE),
-		ctrlKey(tcell.KeyCtrlE):
+	case "end-of-line":
 		e.cursor = len(e.value)
-	case key(tcell.KeyCtrlK),
-		ctrlKey(tcell.KeyCtrlK):
+	case "kill-line":
 		e.kill()
-	case key(tcell.KeyCtrlY),
-		ctrlKey(tcell.KeyCtrlY):
+	case "yank":
 		e.insert(e.killspace...)
-	case key(tcell.KeyCtrlW),
-		ctrlKey(tcell.KeyCtrlW):
+	case "unix-word-rubout":
 		e.unixWordRubout()
 	default:
 		// Unknown key/combination, not handled
@@ -470,11 +1604,36 @@ func (e *Editor) unixWordRubout() {
 	e.cursor = pos
 }
 
+// Prompt is an Editor used to ask the user a question at the bottom of the
+// screen. When user presses Enter, the answer is passed to the Answer func.
+type Prompt struct {
+	*Editor
+	Answer func(reply string)
+}
+
+func NewPrompt(question, value string, answer func(reply string)) *Prompt {
+	return &Prompt{
+		Editor: NewEditor(question, value),
+		Answer: answer,
+	}
+}
+
+func (p *Prompt) DrawTo(region Region, style tcell.Style, setcursor func(x, y int)) {
+	// Clear the whole line first, so that the question stands out from the text below it
+	for x := 0; x < region.W; x++ {
+		region.SetCell(x, 0, style, ' ')
+	}
+	p.Editor.DrawTo(region, style, setcursor)
+}
+
 type BufView struct {
 	// TODO: Wrap bool
 	Y   int // Y of the view in the Buf, for down/up scrolling
 	X   int // X of the view in the Buf, for left/right scrolling
 	Buf *Buf
+	// Prev are lines of a previous version of Buf contents, if any; lines
+	// which differ from them are highlighted
+	Prev [][]byte
 }
 
 func (v *BufView) DrawTo(region Region) {
@@ -497,6 +1656,7 @@ func (v *BufView) DrawTo(region Region) {
 	}
 
 	lclip := false
+	style := theme.Output
 	drawch := func(x, y int, ch rune) {
 		if x <= v.X && v.X != 0 {
 			x, ch = 0, '«'
@@ -507,7 +1667,7 @@ func (v *BufView) DrawTo(region Region) {
 		if x >= region.W {
 			x, ch = region.W-1, '»'
 		}
-		region.SetCell(x, y, tcell.StyleDefault, ch)
+		region.SetCell(x, y, style, ch)
 	}
 	endline := func(x, y int) {
 		x -= v.X
@@ -519,38 +1679,44 @@ func (v *BufView) DrawTo(region Region) {
 		}
 		lclip = false
 		for ; x < region.W; x++ {
-			region.SetCell(x, y, tcell.StyleDefault, ' ')
+			region.SetCell(x, y, theme.Output, ' ')
 		}
 	}
 
 	x, y := 0, 0
 	// TODO: handle runes properly, including their visual width (mattn/go-runewidth)
-	for {
-		ch, _, err := r.ReadRune()
-		if y >= region.H || err == io.EOF {
+	for y < region.H {
+		line, err := r.ReadBytes('\n')
+		if len(line) == 0 && err == io.EOF {
 			break
-		} else if err != nil {
+		} else if err != nil && err != io.EOF {
 			panic(err)
 		}
-		switch ch {
-		case '\n':
-			endline(x, y)
-			x, y = 0, y+1
-			continue
-		case '\t':
-			const tabwidth = 8
-			drawch(x, y, ' ')
-			for x%tabwidth < (tabwidth - 1) {
-				x++
-				if x >= region.W {
-					break
-				}
+		style = theme.Output
+		if v.changed(v.Y+y, line) {
+			style = theme.Changed
+		}
+		for _, ch := range string(line) {
+			switch ch {
+			case '\n':
+				endline(x, y)
+				x, y = 0, y+1
+				continue
+			case '\t':
+				const tabwidth = 8
 				drawch(x, y, ' ')
+				for x%tabwidth < (tabwidth - 1) {
+					x++
+					if x >= region.W {
+						break
+					}
+					drawch(x, y, ' ')
+				}
+			default:
+				drawch(x, y, ch)
 			}
-		default:
-			drawch(x, y, ch)
+			x++
 		}
-		x++
 	}
 	for ; y < region.H; y++ {
 		endline(x, y)
@@ -558,39 +1724,44 @@ func (v *BufView) DrawTo(region Region) {
 	}
 }
 
+// changed checks if line number i differs from the same line in v.Prev.
+func (v *BufView) changed(i int, line []byte) bool {
+	if v.Prev == nil {
+		return false
+	}
+	return i >= len(v.Prev) || !bytes.Equal(v.Prev[i], bytes.TrimSuffix(line, []byte("\n")))
+}
+
 func (v *BufView) HandleKey(ev *tcell.EventKey, scrollY int) bool {
 	const scrollX = 8 // When user scrolls horizontally, move by this many characters
-	switch getKey(ev) {
+	switch viewKeys.action(getKey(ev)) {
 	//
 	// Vertical scrolling
 	//
-	case key(tcell.KeyUp):
+	case "scroll-up":
 		v.Y--
 		v.normalizeY()
-	case key(tcell.KeyDown):
+	case "scroll-down":
 		v.Y++
 		v.normalizeY()
-	case key(tcell.KeyPgDn):
+	case "page-down":
 		// TODO: in top-right corner of Buf area, draw current line number & total # of lines
 		v.Y += scrollY
 		v.normalizeY()
-	case key(tcell.KeyPgUp):
+	case "page-up":
 		v.Y -= scrollY
 		v.normalizeY()
 	//
 	// Horizontal scrolling
 	//
-	case altKey(tcell.KeyLeft),
-		ctrlKey(tcell.KeyLeft):
+	case "scroll-left":
 		v.X -= scrollX
 		if v.X < 0 {
 			v.X = 0
 		}
-	case altKey(tcell.KeyRight),
-		ctrlKey(tcell.KeyRight):
+	case "scroll-right":
 		v.X += scrollX
-	case altKey(tcell.KeyHome),
-		ctrlKey(tcell.KeyHome):
+	case "scroll-home":
 		v.X = 0
 	default:
 		// Unknown key/combination, not handled
@@ -620,6 +1791,14 @@ func count(r io.Reader, b byte) (n int) {
 	}
 }
 
+// NewBufString creates a Buf with fixed contents.
+func NewBufString(s string) *Buf {
+	buf := NewBuf(len(s))
+	buf.n = copy(buf.bytes, s)
+	buf.status = bufEOF
+	return buf
+}
+
 func NewBuf(bufsize int) *Buf {
 	// TODO: make buffer size dynamic (growable by pressing a key)
 	buf := &Buf{bytes: make([]byte, bufsize)}
@@ -634,6 +1813,11 @@ type Buf struct {
 	cond   *sync.Cond
 	status bufStatus
 	n      int
+	// rest is the unread part of input, if capturing stopped due to full buffer
+	rest io.Reader
+	// detached is set when capturing was stopped with Detach; the rest of
+	// input is then copied to it
+	detached *io.PipeWriter
 }
 
 type bufStatus int
@@ -655,15 +1839,24 @@ func (b *Buf) capture(r io.Reader, notify func()) {
 		n, err := r.Read(b.bytes[b.n:])
 
 		b.mu.Lock()
-		for b.status == bufPaused {
+		for b.status == bufPaused && b.detached == nil {
 			b.cond.Wait()
 		}
+		if b.detached != nil {
+			// Data read after Detach is not part of the buffer anymore, but
+			// of the detached reader
+			pw := b.detached
+			b.mu.Unlock()
+			forward(pw, b.bytes[b.n:b.n+n], r, err)
+			return
+		}
 		b.n += n
 		if err == io.EOF {
 			b.status = bufEOF
 		}
-		if b.n == len(b.bytes) {
+		if b.n == len(b.bytes) && err == nil {
 			// TODO: remove this when we can grow the buffer
+			b.rest = r
 			err = io.EOF
 		}
 		b.cond.Broadcast()
@@ -680,6 +1873,64 @@ func (b *Buf) capture(r io.Reader, notify func()) {
 	}
 }
 
+// Detach stops capturing data into the buffer, and returns a reader which
+// yields the complete input: first the data captured so far, then the rest of
+// the input stream which was not read yet (if any). It doesn't wait for
+// a pending read from the input stream: data read by it is passed on to the
+// returned reader.
+func (b *Buf) Detach() io.Reader {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	data := bytes.NewReader(b.bytes[:b.n])
+	switch {
+	case b.rest != nil:
+		return io.MultiReader(data, b.rest)
+	case b.status == bufEOF || b.n == len(b.bytes):
+		return data
+	case b.detached == nil:
+		// Wake up the capture func, if it's paused
+		var pr *io.PipeReader
+		pr, b.detached = io.Pipe()
+		b.cond.Broadcast()
+		return io.MultiReader(data, pr)
+	}
+	panic("up: Buf detached twice")
+}
+
+// forward writes data, followed by the rest of r, to w. Reading from r stops
+// at first error, which can be the result of the last read of data.
+func forward(w *io.PipeWriter, data []byte, r io.Reader, err error) {
+	if _, werr := w.Write(data); werr != nil {
+		return
+	}
+	if err == nil {
+		_, err = io.Copy(w, r)
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	w.CloseWithError(err)
+}
+
+// Complete returns true if no more data will be captured into the buffer.
+func (b *Buf) Complete() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.status == bufEOF || b.n == len(b.bytes)
+}
+
+// Bytes returns the data captured so far.
+func (b *Buf) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.bytes[:b.n]
+}
+
+// Lines returns the data captured so far, split into lines.
+func (b *Buf) Lines() [][]byte {
+	return bytes.Split(b.Bytes(), []byte("\n"))
+}
+
 func (b *Buf) Pause(pause bool) {
 	b.mu.Lock()
 	if pause {
@@ -743,34 +1994,228 @@ type funcReader func([]byte) (int, error)
 
 func (f funcReader) Read(p []byte) (int, error) { return f(p) }
 
+// saveOutput writes contents of buf to a file at path in background, waiting
+// until all data is captured in buf. When finished, done is called with the
+// result.
+func saveOutput(buf *Buf, path string, done func(error)) {
+	go func() {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			done(err)
+			return
+		}
+		_, err = io.Copy(f, buf.NewReader(true))
+		if err != nil {
+			f.Close()
+			done(err)
+			return
+		}
+		done(f.Close())
+	}()
+}
+
+// emitAndExit kills the subprocess, then runs the command once more over the
+// complete input, produced by inputProducer (if any), writing its output to
+// up's standard output. The exit code of up is set to the exit code of the
+// command. The pending run of input command (see --every) is killed.
+func emitAndExit(shell []string, command string, subprocess *Subprocess, input *Buf, inputProducer, pendingInput *Subprocess) {
+	subprocess.Kill()
+	pendingInput.Kill()
+	subprocess.Wait()
+	runAndExit(shell, command, input.Detach(), inputProducer, pendingInput)
+}
+
+// runAndExit runs the command over data from stdin, writing its output to up's
+// standard output, then exits with the exit code of the command. Producers of
+// the data, if any, are killed before exiting.
+func runAndExit(shell []string, command string, stdin io.Reader, producers ...*Subprocess) {
+	exit := func(code int) {
+		for _, p := range producers {
+			p.Kill()
+		}
+		for _, p := range producers {
+			p.Wait()
+		}
+		os.Exit(code)
+	}
+	fail := func(err error) {
+		os.Stderr.WriteString("error: " + err.Error() + "\n")
+		exit(1)
+	}
+	if command == "" {
+		// Empty command means showing the input data, as if `cat` command was typed
+		_, err := io.Copy(os.Stdout, stdin)
+		if err != nil {
+			fail(err)
+		}
+		exit(0)
+	}
+	cmd := exec.Command(shell[0], append(shell[1:], command)...)
+	cmd.Stdin = stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	setProcessGroup(cmd)
+	err := prepareCommand(cmd)
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		fail(err)
+	}
+
+	// Kill all processes of the pipeline on timeout, or when up itself is
+	// killed or interrupted (they are in their own process group, so they
+	// don't get signals from the terminal)
+	done, killed := make(chan struct{}), make(chan struct{})
+	exitCode := make(chan int, 1)
+	go func() {
+		defer close(killed)
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+		var deadline <-chan time.Time
+		if *timeout > 0 {
+			deadline = time.After(*timeout)
+		}
+		select {
+		case <-done:
+			return
+		case <-deadline:
+			os.Stderr.WriteString(fmt.Sprintf("up: pipeline killed: timeout of %s exceeded\n", *timeout))
+			// Same exit code as used by timeout(1)
+			exitCode <- 124
+		case sig := <-stop:
+			exitCode <- 128 + int(sig.(syscall.Signal))
+		}
+		terminateProcessGroup(cmd)
+		select {
+		case <-done:
+		case <-time.After(killGracePeriod):
+		}
+		// Some processes may still be running, even if the shell exited
+		killProcessGroup(cmd)
+	}()
+	err = cmd.Wait()
+	close(done)
+	<-killed
+	select {
+	case code := <-exitCode:
+		exit(code)
+	default:
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		exit(exitErr.ExitCode())
+	} else if err != nil {
+		fail(err)
+	}
+	exit(0)
+}
+
 type Subprocess struct {
 	Buf    *Buf
 	cancel context.CancelFunc
+	// terminated is closed when the pipeline exited or was asked to exit
+	terminated chan struct{}
+	// finished is closed when the pipeline exited, or was killed forcibly
+	// after being asked to exit
+	finished chan struct{}
+
+	mu    sync.Mutex // guards the following fields
+	limit string
+	err   error
 }
 
 func StartSubprocess(shell []string, command string, stdin *Buf, notify func()) *Subprocess {
-	ctx, cancel := context.WithCancel(context.TODO())
+	cmd := exec.Command(shell[0], append(shell[1:], command)...)
+	cmd.Stdin = stdin.NewReader(true)
+	return startCommand(cmd, len(stdin.bytes), true, notify)
+}
+
+// StartProducer runs a command whose output is used as input data, instead of
+// data piped to up. Unlike pipelines, the producer is not sandboxed nor
+// limited, and its errors are not mixed into the data, but reported by Err.
+func StartProducer(args []string, bufsize int, notify func()) *Subprocess {
+	return startCommand(exec.Command(args[0], args[1:]...), bufsize, false, notify)
+}
+
+func startCommand(cmd *exec.Cmd, bufsize int, pipeline bool, notify func()) *Subprocess {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if pipeline && *timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), *timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
 	r, w := io.Pipe()
 	p := &Subprocess{
-		Buf:    NewBuf(len(stdin.bytes)).StartCapturing(r, notify),
-		cancel: cancel,
+		Buf:        NewBuf(bufsize).StartCapturing(r, notify),
+		cancel:     cancel,
+		terminated: make(chan struct{}),
+		finished:   make(chan struct{}),
+	}
+	fail := func(err error) {
+		p.mu.Lock()
+		p.err = err
+		p.mu.Unlock()
+		if pipeline {
+			fmt.Fprintf(w, "up: %s", err)
+		}
 	}
 
-	cmd := exec.CommandContext(ctx, shell[0], append(shell[1:], command)...)
 	cmd.Stdout = w
 	cmd.Stderr = w
-	cmd.Stdin = stdin.NewReader(true)
-	err := cmd.Start()
+	setProcessGroup(cmd)
+	var err error
+	if pipeline {
+		err = prepareCommand(cmd)
+	}
+	if err == nil {
+		err = cmd.Start()
+	}
 	if err != nil {
-		fmt.Fprintf(w, "up: %s", err)
+		fail(err)
 		w.Close()
+		close(p.terminated)
+		close(p.finished)
 		return p
 	}
 	log.Println(cmd.Path)
+	done := make(chan struct{})
+	go func() {
+		// Kill all processes of the pipeline, not only the shell, otherwise
+		// they could keep running in background
+		select {
+		case <-ctx.Done():
+			terminateProcessGroup(cmd)
+			close(p.terminated)
+			select {
+			case <-done:
+			case <-time.After(killGracePeriod):
+			}
+			// Some processes may still be running, even if the shell exited
+			killProcessGroup(cmd)
+			close(p.finished)
+		case <-done:
+			close(p.terminated)
+			close(p.finished)
+		}
+	}()
 	go func() {
 		err = cmd.Wait()
+		close(done)
+		if limit := exceededLimit(ctx, cmd.ProcessState); pipeline && limit != "" {
+			p.mu.Lock()
+			p.limit = limit
+			p.mu.Unlock()
+			err = errors.New("pipeline killed: " + limit)
+		} else if pipeline && err != nil && *memLimit > 0 && cmd.ProcessState != nil {
+			// When the memory limit is exceeded, allocations fail, and
+			// processes usually exit with an error or crash, which can't be
+			// told apart from other failures; so only show how much memory
+			// was used
+			err = fmt.Errorf("%s (used %d MiB of the --mem-limit of %d MiB)", err, peakMemory(cmd.ProcessState)/1024/1024, *memLimit)
+		}
 		if err != nil {
-			fmt.Fprintf(w, "up: %s", err)
+			fail(err)
 			log.Printf("Wait returned error: %s", err)
 		}
 		w.Close()
@@ -778,11 +2223,97 @@ func StartSubprocess(shell []string, command string, stdin *Buf, notify func())
 	return p
 }
 
+// prepareCommand applies sandboxing and resource limits requested by user to
+// a pipeline command.
+func prepareCommand(cmd *exec.Cmd) error {
+	var helper []string
+	if *sandboxMode {
+		err := sandboxCommand(cmd)
+		if err != nil {
+			return err
+		}
+		helper = append(helper, "sandbox")
+	}
+	if *cpuLimit > 0 {
+		helper = append(helper, fmt.Sprintf("cpu=%d", int64(math.Ceil(cpuLimit.Seconds()))))
+	}
+	if *memLimit > 0 {
+		helper = append(helper, fmt.Sprintf("mem=%d", int64(*memLimit)*1024*1024))
+	}
+	if len(helper) == 0 {
+		return nil
+	}
+	return helperCommand(cmd, strings.Join(helper, ","))
+}
+
+// exceededLimit checks if a finished process was killed because of exceeding
+// one of the limits set by user, and returns a description of the limit.
+func exceededLimit(ctx context.Context, state *os.ProcessState) string {
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Sprintf("timeout of %s exceeded", *timeout)
+	}
+	if ctx.Err() != nil || state == nil || state.Success() {
+		return ""
+	}
+	status, ok := state.Sys().(syscall.WaitStatus)
+	if !ok {
+		return ""
+	}
+	// When the CPU time limit is exceeded, a process gets SIGXCPU, and if it
+	// does not exit, SIGKILL. If it was a subprocess of the shell, the shell
+	// usually exits with code 128+signal number. The signals may also come
+	// from elsewhere, so check if the CPU time was really used up (it includes
+	// the time of subprocesses of the shell, and may be measured slightly
+	// below the limit).
+	killed := func(sig syscall.Signal) bool {
+		return status.Signaled() && status.Signal() == sig || status.Exited() && status.ExitStatus() == 128+int(sig)
+	}
+	if *cpuLimit > 0 && (killed(sigXCPU) || killed(syscall.SIGKILL)) &&
+		state.UserTime()+state.SystemTime() >= *cpuLimit*9/10 {
+		return fmt.Sprintf("CPU time limit of %s exceeded", *cpuLimit)
+	}
+	return ""
+}
+
+// Kill asks all processes of the pipeline to terminate. Processes which don't
+// exit within killGracePeriod are killed forcibly in background (see Wait).
 func (s *Subprocess) Kill() {
 	if s == nil {
 		return
 	}
 	s.cancel()
+	<-s.terminated
+}
+
+// Wait waits until the pipeline exits, or until its processes are killed
+// forcibly after Kill. It must be called before up exits, otherwise processes
+// which ignore the request to terminate could keep running.
+func (s *Subprocess) Wait() {
+	if s == nil {
+		return
+	}
+	<-s.finished
+}
+
+// Err returns the error with which the subprocess failed, if any.
+func (s *Subprocess) Err() error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// ExceededLimit returns a description of a limit, like --timeout, which caused
+// the subprocess to be killed, or empty string if none.
+func (s *Subprocess) ExceededLimit() string {
+	if s == nil {
+		return ""
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.limit
 }
 
 type key int32
@@ -791,7 +2322,339 @@ func getKey(ev *tcell.EventKey) key { return key(ev.Modifiers())<<16 + key(ev.Ke
 func altKey(base tcell.Key) key     { return key(tcell.ModAlt)<<16 + key(base) }
 func ctrlKey(base tcell.Key) key    { return key(tcell.ModCtrl)<<16 + key(base) }
 
-func writeScript(shell []string, command string, tui tcell.Screen) {
+// ctrlKeys returns the key combination both with and without the Ctrl
+// modifier, as terminals differ in how they report Ctrl-letter keys.
+func ctrlKeys(base tcell.Key) []key { return []key{key(base), ctrlKey(base)} }
+
+func (k key) String() string {
+	base := tcell.Key(k & 0xffff)
+	name, ok := tcell.KeyNames[base]
+	if !ok {
+		name = fmt.Sprintf("Key[%d]", base)
+	}
+	mods := tcell.ModMask(k >> 16)
+	if mods&tcell.ModShift != 0 {
+		name = "Shift-" + name
+	}
+	if mods&tcell.ModAlt != 0 {
+		name = "Alt-" + name
+	}
+	if mods&tcell.ModCtrl != 0 && !strings.HasPrefix(name, "Ctrl-") {
+		name = "Ctrl-" + name
+	}
+	return name
+}
+
+// keymap describes which keys trigger which actions in some part of the UI.
+type keymap []binding
+
+type binding struct {
+	action string
+	keys   []key
+	help   string
+}
+
+// action returns the name of the action bound to key k, or empty string if
+// the key is not bound.
+func (m keymap) action(k key) string {
+	for _, b := range m {
+		for _, bk := range b.keys {
+			if bk == k {
+				return b.action
+			}
+		}
+	}
+	return ""
+}
+
+var editorKeys = keymap{
+	// See https://github.com/nsf/termbox-go/issues/145 for the two Backspaces
+	{"backward-delete-char", []key{key(tcell.KeyBackspace), key(tcell.KeyBackspace2)}, "delete character before cursor"},
+	{"delete-char", []key{key(tcell.KeyDelete)}, "delete character under cursor"},
+	{"backward-char", append(ctrlKeys(tcell.KeyCtrlB), key(tcell.KeyLeft)), "move cursor left"},
+	{"forward-char", append(ctrlKeys(tcell.KeyCtrlF), key(tcell.KeyRight)), "move cursor right"},
+	{"beginning-of-line", ctrlKeys(tcell.KeyCtrlA), "move cursor to beginning of the command"},
+	{"end-of-line", ctrlKeys(tcell.KeyCtrlE), "move cursor to end of the command"},
+	{"kill-line", ctrlKeys(tcell.KeyCtrlK), "cut text from cursor to end of the command"},
+	{"yank", ctrlKeys(tcell.KeyCtrlY), "paste the last cut text"},
+	{"unix-word-rubout", ctrlKeys(tcell.KeyCtrlW), "cut the word before cursor"},
+}
+
+var viewKeys = keymap{
+	{"scroll-up", []key{key(tcell.KeyUp)}, "scroll output up by a line"},
+	{"scroll-down", []key{key(tcell.KeyDown)}, "scroll output down by a line"},
+	{"page-up", []key{key(tcell.KeyPgUp)}, "scroll output up by a page"},
+	{"page-down", []key{key(tcell.KeyPgDn)}, "scroll output down by a page"},
+	{"scroll-left", []key{ctrlKey(tcell.KeyLeft), altKey(tcell.KeyLeft)}, "scroll output left"},
+	{"scroll-right", []key{ctrlKey(tcell.KeyRight), altKey(tcell.KeyRight)}, "scroll output right"},
+	{"scroll-home", []key{ctrlKey(tcell.KeyHome), altKey(tcell.KeyHome)}, "scroll output to the leftmost column"},
+}
+
+var globalKeys = keymap{
+	{"run", []key{key(tcell.KeyEnter)}, "execute the pipeline command, updating the pipeline output panel"},
+	{"help", []key{key(tcell.KeyF1)}, "show this help screen"},
+	{"write-script", ctrlKeys(tcell.KeyCtrlX), "exit and write the pipeline to up1.sh (or if it exists then to up2.sh, etc. till up1000.sh), or append it to a file as a shell function, alias, Makefile or justfile target"},
+	{"quit", append(ctrlKeys(tcell.KeyCtrlC), ctrlKeys(tcell.KeyCtrlD)...), "quit without saving and emit the pipeline on standard error"},
+	{"emit-output", ctrlKeys(tcell.KeyCtrlP), "quit and emit the full output of the pipeline on standard output"},
+	{"save-output", []key{key(tcell.KeyF2)}, "save the pipeline output to a file"},
+	{"view-in-pager", append(ctrlKeys(tcell.KeyCtrlO), key(tcell.KeyF3)), "view the displayed output in $PAGER"},
+	{"view-in-editor", []key{key(tcell.KeyF4)}, "open the displayed output in $VISUAL or $EDITOR"},
+	{"edit-command", ctrlKeys(tcell.KeyCtrlV), "edit the pipeline command in $VISUAL or $EDITOR, then run it"},
+	{"pause-input", ctrlKeys(tcell.KeyCtrlS), "temporarily freeze a long-running input, injecting a fake EOF into the buffer"},
+	{"resume-input", ctrlKeys(tcell.KeyCtrlQ), "unfreeze back the input after pausing it"},
+	{"rerun-input", ctrlKeys(tcell.KeyCtrlR), "re-run the input command (see --input-cmd), refreshing the input data"},
+}
+
+// keyNames returns a human readable list of keys bound to an action.
+func keyNames(m keymap, action string) string {
+	var names []string
+	seen := map[string]bool{}
+	for _, b := range m {
+		if b.action != action {
+			continue
+		}
+		for _, k := range b.keys {
+			if name := k.String(); !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	if len(names) == 0 {
+		return "(none)"
+	}
+	return strings.Join(names, ", ")
+}
+
+// hintText returns a short help about most important keys, to show initially
+// at the bottom of the screen.
+func hintText() string {
+	short := func(m keymap, action string) string {
+		names := keyNames(m, action)
+		names = strings.SplitN(names, ", ", 2)[0]
+		return strings.Replace(names, "Ctrl-", "^", -1)
+	}
+	return short(globalKeys, "help") + " help  " +
+		short(globalKeys, "run") + " runs  " +
+		short(globalKeys, "write-script") + " exit (" + short(globalKeys, "quit") + " nosave)  " +
+		short(viewKeys, "page-up") + "/" + short(viewKeys, "page-down") + "/" +
+		short(viewKeys, "scroll-up") + "/" + short(viewKeys, "scroll-down") + "/" +
+		short(viewKeys, "scroll-left") + "/" + short(viewKeys, "scroll-right") + " scroll  " +
+		short(globalKeys, "pause-input") + " pause (" + short(globalKeys, "resume-input") + " end)  " +
+		short(globalKeys, "save-output") + " save  " +
+		short(globalKeys, "view-in-pager") + " view"
+}
+
+// parseKey converts a key name, like "Ctrl-X", "Alt-Left" or "F5", to the key
+// combinations it represents.
+func parseKey(name string) ([]key, error) {
+	names := map[string]tcell.Key{}
+	for k, n := range tcell.KeyNames {
+		names[strings.ToLower(n)] = k
+	}
+	// Ctrl-letter keys have their own codes, but some terminals report them
+	// with Ctrl modifier too
+	if base, ok := names[strings.ToLower(name)]; ok {
+		if strings.HasPrefix(tcell.KeyNames[base], "Ctrl-") {
+			return ctrlKeys(base), nil
+		}
+		return []key{key(base)}, nil
+	}
+	mods := tcell.ModNone
+	rest := name
+	for {
+		switch lower := strings.ToLower(rest); {
+		case strings.HasPrefix(lower, "ctrl-"):
+			mods |= tcell.ModCtrl
+		case strings.HasPrefix(lower, "alt-"):
+			mods |= tcell.ModAlt
+		case strings.HasPrefix(lower, "shift-"):
+			mods |= tcell.ModShift
+		default:
+			base, ok := names[lower]
+			if !ok || base == tcell.KeyRune {
+				return nil, fmt.Errorf("unknown key name: %q", name)
+			}
+			return []key{key(mods)<<16 + key(base)}, nil
+		}
+		rest = rest[strings.IndexByte(rest, '-')+1:]
+	}
+}
+
+// applyKeys changes key bindings of actions to the ones specified by key names.
+func applyKeys(bindings map[string][]string) error {
+	for action, names := range bindings {
+		var keys []key
+		for _, name := range names {
+			k, err := parseKey(name)
+			if err != nil {
+				return fmt.Errorf("in key binding for %s: %s", action, err)
+			}
+			keys = append(keys, k...)
+		}
+		found := false
+		for _, m := range []keymap{editorKeys, viewKeys, globalKeys} {
+			for i := range m {
+				if m[i].action == action {
+					m[i].keys = keys
+					found = true
+				}
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown action in key bindings: %s", action)
+		}
+	}
+	return checkKeyConflicts(editorKeys, viewKeys, globalKeys)
+}
+
+// checkKeyConflicts verifies that no key is bound to more than one action.
+func checkKeyConflicts(keymaps ...keymap) error {
+	bound := map[key]string{}
+	for _, m := range keymaps {
+		for _, b := range m {
+			for _, k := range b.keys {
+				if other, ok := bound[k]; ok && other != b.action {
+					return fmt.Errorf("key %s bound to both %s and %s", k, other, b.action)
+				}
+				bound[k] = b.action
+			}
+		}
+	}
+	return nil
+}
+
+// helpText builds the contents of the help screen, describing the current key
+// bindings and licenses of up and its dependencies.
+// helpText returns the contents of the help screen. If web is not nil, the
+// address of the web page is shown too.
+func helpText(web *webUI) string {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "Ultimate Plumber v%s https://github.com/akavel/up\n", version)
+	if web != nil {
+		fmt.Fprintf(buf, "The pipeline is shown on a web page at: %s\n", web.URL())
+	}
+	fmt.Fprintf(buf, "Press Esc, %s or q to close help; scroll using the pipeline output keys.\n", keyNames(globalKeys, "help"))
+	fmt.Fprintf(buf, "Keys can be changed in [keys] section of the config file, like: edit-command = [\"F5\", \"Ctrl-G\"]\n")
+	sections := []struct {
+		title string
+		keys  keymap
+	}{
+		{"PIPELINE COMMAND EDITOR", editorKeys},
+		{"PIPELINE OUTPUT PANEL", viewKeys},
+		{"OTHER KEYS", globalKeys},
+	}
+	for _, s := range sections {
+		fmt.Fprintf(buf, "\n%s\n\n", s.title)
+		for _, b := range s.keys {
+			fmt.Fprintf(buf, "  %-24s %-22s %s\n", keyNames(s.keys, b.action), b.action, b.help)
+		}
+	}
+	fmt.Fprintf(buf, "\nLICENSES\n")
+	printLicenses(buf)
+	return buf.String()
+}
+
+// scriptMarker ends the here-document with the pipeline in scripts which
+// can't run the pipeline directly with a shebang line.
+const scriptMarker = "UP_PIPELINE"
+
+// scriptText returns contents of a script running command with shell like up
+// does. The script starts with a header describing when and how it was made,
+// including the source of input data.
+func scriptText(shell []string, command, input string, now time.Time) string {
+	buf := &strings.Builder{}
+	shebang := scriptShebang(shell)
+	if shebang != "" {
+		fmt.Fprintf(buf, "%s\n", shebang)
+	} else {
+		fmt.Fprintf(buf, "#!/bin/sh\n")
+	}
+	fmt.Fprintf(buf, "# Written by up (Ultimate Plumber) v%s https://github.com/akavel/up\n", version)
+	fmt.Fprintf(buf, "# Date: %s\n", now.Format("2006-01-02 15:04:05 -0700"))
+	fmt.Fprintf(buf, "# Input: %s\n", strings.Replace(input, "\n", " ", -1))
+	if shebang == "" {
+		// The shell doesn't take the pipeline like sh -c does, so pass it
+		// the same way as up
+		fmt.Fprintf(buf, "exec %s \"$(cat <<'%s'\n%s\n%s\n)\"\n", shellJoin(shell), scriptMarker, command, scriptMarker)
+		return buf.String()
+	}
+	switch path.Base(shell[0]) {
+	case "bash", "zsh", "ksh", "mksh":
+		// Make the script fail if any command in the pipeline failed
+		fmt.Fprintf(buf, "set -o pipefail\n")
+	}
+	fmt.Fprintf(buf, "%s\n", command)
+	return buf.String()
+}
+
+// scriptShebang returns a shebang line which runs a script with shell, if
+// shell takes a command with -c option (like sh -c), and a shebang line can
+// pass its other arguments portably. Otherwise, it returns "".
+func scriptShebang(shell []string) string {
+	if len(shell) < 2 || shell[len(shell)-1] != "-c" {
+		return ""
+	}
+	args := shell[:len(shell)-1]
+	for _, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n\\'\"$") {
+			return ""
+		}
+	}
+	line := ""
+	switch {
+	case len(args) == 1 && path.IsAbs(args[0]):
+		line = "#!" + args[0]
+	case len(args) == 1:
+		line = "#!/usr/bin/env " + args[0]
+	default:
+		// Most systems pass everything after the interpreter as a single
+		// argument, so let env split it
+		line = "#!/usr/bin/env -S " + strings.Join(args, " ")
+	}
+	// Some systems truncate longer shebang lines
+	if len(line) > 127 {
+		return ""
+	}
+	return line
+}
+
+// scriptCommand extracts the pipeline from contents of a script written with
+// writeScript. If the script sets the pipefail option of the shell, pipefail
+// is true.
+func scriptCommand(script string) (command string, pipefail bool) {
+	// Skip the shebang line, and the header written by scriptText; other
+	// comments are a part of the pipeline
+	lines := strings.SplitAfter(script, "\n")
+	i := 0
+	if strings.HasPrefix(lines[0], "#!") {
+		i++
+	}
+	if i < len(lines) && strings.HasPrefix(lines[i], "# Written by up (Ultimate Plumber) ") {
+		i++
+		for _, prefix := range []string{"# Date: ", "# Input: "} {
+			if i < len(lines) && strings.HasPrefix(lines[i], prefix) {
+				i++
+			}
+		}
+		if i < len(lines) && lines[i] == "set -o pipefail\n" {
+			pipefail = true
+			i++
+		}
+	}
+	script = strings.Join(lines[i:], "")
+	// The pipeline may be in a here-document passed to the shell
+	start := "<<'" + scriptMarker + "'\n"
+	if j := strings.Index(script, start); strings.HasPrefix(script, "exec ") && j != -1 {
+		script = script[j+len(start):]
+		if end := strings.Index("\n"+script, "\n"+scriptMarker+"\n"); end != -1 {
+			script = script[:end]
+		}
+	}
+	return strings.TrimSpace(script), pipefail
+}
+
+func writeScript(shell []string, command, input string, tui tcell.Screen) {
 	os.Stderr.WriteString("up: Ultimate Plumber v" + version + " https://github.com/akavel/up\n")
 	var f *os.File
 	var err error
@@ -821,8 +2684,7 @@ func writeScript(shell []string, command string, tui tcell.Screen) {
 	goto fallback_tmp
 
 try_file:
-	// NOTE: currently not supporting multi-word shell in upNNN.sh unfortunately :(
-	_, err = fmt.Fprintf(f, "#!%s\n%s\n", shell[0], command)
+	_, err = f.WriteString(scriptText(shell, command, input, time.Now()))
 	if err != nil {
 		goto fallback_tmp
 	}
@@ -840,7 +2702,7 @@ fallback_tmp:
 	if err != nil {
 		goto fallback_print
 	}
-	_, err = fmt.Fprintf(f, "#!%s\n%s\n", shell, command)
+	_, err = f.WriteString(scriptText(shell, command, input, time.Now()))
 	if err != nil {
 		goto fallback_print
 	}
@@ -880,10 +2742,69 @@ func TuiRegion(tui tcell.Screen, x, y, w, h int) Region {
 	}
 }
 
-var (
-	whiteOnBlue  = tcell.StyleDefault.Foreground(tcell.ColorWhite).Background(tcell.ColorBlue)
-	whiteOnDBlue = tcell.StyleDefault.Foreground(tcell.ColorWhite).Background(tcell.ColorNavy)
-)
+// Theme defines styles of all parts of the UI.
+type Theme struct {
+	Edited  tcell.Style // command & status, when the command was edited since it was run
+	Current tcell.Style // command & status, when the output is of the displayed command
+	Live    tcell.Style // like Current, but in --unsafe-full-throttle mode
+	Message tcell.Style // messages and questions at the bottom of the screen
+	Error   tcell.Style // error messages
+	Output  tcell.Style // contents of output panel and help
+	Changed tcell.Style // lines of output which changed since the input was refreshed (see --every)
+}
+
+var themes = map[string]Theme{
+	"dark": {
+		Edited:  tcell.StyleDefault.Foreground(tcell.ColorWhite).Background(tcell.ColorBlue),
+		Current: tcell.StyleDefault.Foreground(tcell.ColorWhite).Background(tcell.ColorNavy),
+		Live:    tcell.StyleDefault.Foreground(tcell.ColorWhite).Background(tcell.ColorPurple),
+		Message: tcell.StyleDefault.Foreground(tcell.ColorWhite).Background(tcell.ColorBlue),
+		Error:   tcell.StyleDefault.Foreground(tcell.ColorWhite).Background(tcell.ColorMaroon),
+		Output:  tcell.StyleDefault,
+		Changed: tcell.StyleDefault.Reverse(true),
+	},
+	"light": {
+		Edited:  tcell.StyleDefault.Foreground(tcell.ColorBlack).Background(tcell.ColorLightSkyBlue),
+		Current: tcell.StyleDefault.Foreground(tcell.ColorBlack).Background(tcell.ColorSilver),
+		Live:    tcell.StyleDefault.Foreground(tcell.ColorBlack).Background(tcell.ColorLightPink),
+		Message: tcell.StyleDefault.Foreground(tcell.ColorBlack).Background(tcell.ColorSilver),
+		Error:   tcell.StyleDefault.Foreground(tcell.ColorMaroon).Background(tcell.ColorSilver).Bold(true),
+		Output:  tcell.StyleDefault,
+		Changed: tcell.StyleDefault.Background(tcell.ColorLightYellow),
+	},
+	"high-contrast": {
+		Edited:  tcell.StyleDefault.Foreground(tcell.ColorBlack).Background(tcell.ColorYellow).Bold(true),
+		Current: tcell.StyleDefault.Reverse(true).Bold(true),
+		Live:    tcell.StyleDefault.Foreground(tcell.ColorBlack).Background(tcell.ColorFuchsia).Bold(true),
+		Message: tcell.StyleDefault.Reverse(true),
+		Error:   tcell.StyleDefault.Foreground(tcell.ColorWhite).Background(tcell.ColorRed).Bold(true),
+		Output:  tcell.StyleDefault,
+		Changed: tcell.StyleDefault.Reverse(true).Bold(true),
+	},
+	// See: https://ethanschoonover.com/solarized/
+	"solarized": {
+		Edited:  tcell.StyleDefault.Foreground(tcell.NewHexColor(0xfdf6e3)).Background(tcell.NewHexColor(0x268bd2)),
+		Current: tcell.StyleDefault.Foreground(tcell.NewHexColor(0x93a1a1)).Background(tcell.NewHexColor(0x073642)),
+		Live:    tcell.StyleDefault.Foreground(tcell.NewHexColor(0xfdf6e3)).Background(tcell.NewHexColor(0xd33682)),
+		Message: tcell.StyleDefault.Foreground(tcell.NewHexColor(0x93a1a1)).Background(tcell.NewHexColor(0x073642)),
+		Error:   tcell.StyleDefault.Foreground(tcell.NewHexColor(0xfdf6e3)).Background(tcell.NewHexColor(0xdc322f)),
+		Output:  tcell.StyleDefault.Foreground(tcell.NewHexColor(0x839496)).Background(tcell.NewHexColor(0x002b36)),
+		Changed: tcell.StyleDefault.Foreground(tcell.NewHexColor(0x002b36)).Background(tcell.NewHexColor(0xb58900)),
+	},
+}
+
+// theme is the currently used Theme
+var theme = themes["dark"]
+
+// themeNames returns a sorted list of names of available themes.
+func themeNames() []string {
+	var names []string
+	for name := range themes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
 
 func drawText(region Region, style tcell.Style, text string) {
 	for x, ch := range text {
@@ -1,6 +1,17 @@
 package main
 
-import "testing"
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+)
 
 func Test_Editor_insert(t *testing.T) {
 	tests := []struct {
@@ -173,3 +184,374 @@ func Test_Editor_unix_word_rubout(t *testing.T) {
 		}
 	}
 }
+
+func Test_parseKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    []key
+		wantErr bool
+	}{
+		{name: "Ctrl-X", want: ctrlKeys(tcell.KeyCtrlX)},
+		{name: "ctrl-x", want: ctrlKeys(tcell.KeyCtrlX)},
+		{name: "Enter", want: []key{key(tcell.KeyEnter)}},
+		{name: "F5", want: []key{key(tcell.KeyF5)}},
+		{name: "Ctrl-Left", want: []key{ctrlKey(tcell.KeyLeft)}},
+		{name: "Alt-Home", want: []key{altKey(tcell.KeyHome)}},
+		{name: "Alt-Ctrl-Right", want: []key{key(tcell.ModAlt|tcell.ModCtrl)<<16 + key(tcell.KeyRight)}},
+		{name: "Hyper-X", wantErr: true},
+		{name: "x", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		have, err := parseKey(tt.name)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: bad error: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(have, tt.want) {
+			t.Errorf("%q: bad keys\nwant: %v\nhave: %v", tt.name, tt.want, have)
+		}
+	}
+}
+
+func Test_checkKeyConflicts(t *testing.T) {
+	err := checkKeyConflicts(editorKeys, viewKeys, globalKeys)
+	if err != nil {
+		t.Errorf("default key bindings conflict: %s", err)
+	}
+
+	err = checkKeyConflicts(
+		keymap{{"kill-line", ctrlKeys(tcell.KeyCtrlK), ""}},
+		keymap{{"quit", append(ctrlKeys(tcell.KeyCtrlC), ctrlKeys(tcell.KeyCtrlK)...), ""}})
+	if err == nil {
+		t.Errorf("expected conflict on Ctrl-K")
+	}
+}
+
+func Test_incompleteCommand(t *testing.T) {
+	tests := []struct {
+		command string
+		want    bool
+	}{
+		{``, false},
+		{`grep foo`, false},
+		{`grep -E 'foo|bar'`, false},
+		{`grep -E 'foo|bar`, true},
+		{`grep "foo`, true},
+		{`grep "foo \" bar"`, false},
+		{`grep 'foo\'`, false},
+		{`grep foo |`, true},
+		{`grep foo | `, true},
+		{`grep foo |&`, true},
+		{`grep foo &&`, true},
+		{`grep foo ||`, true},
+		{`sleep 1 &`, false},
+		{`grep foo \`, true},
+		{`grep \( foo`, false},
+		{`echo $(date`, true},
+		{`echo $(date)`, false},
+		{`awk '{print $1' file`, false},
+		{`awk '{print $1}'`, false},
+		{`{ echo; echo`, true},
+		{`grep foo # it's a comment`, false},
+		{"grep foo # it's a comment\n| wc", false},
+		{`grep a#'b`, true},
+	}
+
+	for _, tt := range tests {
+		have := incompleteCommand(tt.command)
+		if have != tt.want {
+			t.Errorf("%q: want %v, have %v", tt.command, tt.want, have)
+		}
+	}
+}
+
+func Test_forbiddenCommand(t *testing.T) {
+	deny := []string{"rm", "dd", "mkfs*", ">"}
+	tests := []struct {
+		command string
+		allow   []string
+		want    string
+	}{
+		{command: `grep rm | wc -l`, want: ``},
+		{command: `rm -rf /`, want: `rm`},
+		{command: `/bin/rm -rf /`, want: `rm`},
+		{command: `grep foo | rm -rf`, want: `rm`},
+		{command: `grep foo; rm -rf`, want: `rm`},
+		{command: `grep foo && dd if=/dev/zero`, want: `dd`},
+		{command: `echo $(rm x)`, want: `rm`},
+		{command: "echo \"`rm x`\"", want: `rm`},
+		{command: `echo "$(rm x)"`, want: `rm`},
+		{command: `echo 'rm x'`, want: ``},
+		{command: `echo "rm | x"`, want: ``},
+		{command: `LC_ALL=C rm x`, want: `rm`},
+		{command: `xargs -n1 rm`, want: `rm`},
+		{command: `sudo -u root rm x`, want: `rm`},
+		{command: `(cd /tmp && mkfs.ext4 /dev/sda)`, want: `mkfs.ext4`},
+		{command: `grep foo | sort`, allow: []string{"grep", "sort"}, want: ``},
+		{command: `grep foo | uniq`, allow: []string{"grep", "sort"}, want: `uniq`},
+		{command: `sort > out.txt`, want: `> out.txt`},
+		{command: `sort >out.txt`, want: `> out.txt`},
+		{command: `sort >| out.txt`, want: `> out.txt`},
+		{command: `sort 2>err.txt | uniq`, want: `> err.txt`},
+		{command: `sort >> out.txt`, want: ``},
+		{command: `sort 2>&1 >/dev/null`, want: ``},
+		{command: `echo 'a > b' "c>d"`, want: ``},
+		{command: `grep foo | tee out.txt`, want: `> out.txt`},
+		{command: `grep foo | tee -a out.txt`, want: ``},
+		{command: `grep foo | tee /dev/stderr`, want: ``},
+		{command: `grep foo | tee`, want: ``},
+		{command: `sh -c 'rm -rf /'`, want: `rm`},
+		{command: `bash -c "dd if=/dev/zero of=x"`, want: `dd`},
+		{command: `bash -ec 'sort | rm x'`, want: `rm`},
+		{command: `bash -o pipefail -c 'sort > out.txt'`, want: `> out.txt`},
+		{command: `sh -c 'grep rm'`, want: ``},
+		{command: `sh script.sh rm`, want: ``},
+		{command: `eval "rm -rf x"`, want: `rm`},
+		{command: `eval echo rm`, want: ``},
+		{command: `sudo sh -c 'rm x'`, want: `rm`},
+		{command: `xargs -n1 sh -c 'dd of=$0'`, want: `dd`},
+		{command: `sh -c 'uniq'`, allow: []string{"sh", "sort"}, want: `uniq`},
+		{command: `find . -delete`, want: `find -delete`},
+		{command: `find . -name '*.o' -exec rm {} +`, want: `rm`},
+		{command: `find . -execdir /bin/rm {} \;`, want: `rm`},
+		{command: `find . -exec grep rm {} ';' -print`, want: ``},
+		{command: `find . -exec sh -c 'rm "$1"' _ {} ';'`, want: `rm`},
+		{command: `find . -name rm`, want: ``},
+	}
+
+	for _, tt := range tests {
+		have := forbiddenCommand(tt.command, deny, tt.allow)
+		if have != tt.want {
+			t.Errorf("%q: want %q, have %q", tt.command, tt.want, have)
+		}
+	}
+}
+
+func Test_Subprocess_Kill(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no process groups on Windows")
+	}
+	// Without killing the whole process group, sleep would keep the output
+	// pipe open until it finished
+	p := StartSubprocess([]string{"/bin/sh", "-c"}, "sleep 30 | cat", NewBufString("x\n"), func() {})
+	time.Sleep(100 * time.Millisecond)
+	p.Kill()
+
+	finished := make(chan struct{})
+	go func() {
+		ioutil.ReadAll(p.Buf.NewReader(true))
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(killGracePeriod + 5*time.Second):
+		t.Errorf("pipeline still running after Kill")
+	}
+}
+
+func Test_scriptCommand(t *testing.T) {
+	header := "# Written by up (Ultimate Plumber) v0.4 https://github.com/akavel/up\n" +
+		"# Date: 2020-10-29 12:30:00 +0000\n" +
+		"# Input: standard input\n"
+	tests := []struct {
+		script   string
+		want     string
+		pipefail bool
+	}{
+		{script: "#!/bin/bash\ngrep foo | wc -l\n", want: "grep foo | wc -l"},
+		{script: "#!/bin/sh\nsort |\n  uniq -c\n", want: "sort |\n  uniq -c"},
+		{script: "grep foo\n", want: "grep foo"},
+		{script: "#!/bin/sh", want: ""},
+		{script: "#!/bin/sh\n# count errors\ngrep -c ERROR\n", want: "# count errors\ngrep -c ERROR"},
+		{script: "#!/bin/sh\n" + header + "# count errors\ngrep -c ERROR\n", want: "# count errors\ngrep -c ERROR"},
+		{script: "#!/usr/bin/env bash\n" + header + "set -o pipefail\n# count errors\ngrep -c ERROR\n", want: "# count errors\ngrep -c ERROR", pipefail: true},
+		{script: "#!/bin/bash\nset -o pipefail\ngrep foo\n", want: "set -o pipefail\ngrep foo"},
+	}
+
+	for _, tt := range tests {
+		have, pipefail := scriptCommand(tt.script)
+		if have != tt.want || pipefail != tt.pipefail {
+			t.Errorf("%q: want %q (pipefail %v), have %q (pipefail %v)", tt.script, tt.want, tt.pipefail, have, pipefail)
+		}
+	}
+}
+
+func Test_scriptText(t *testing.T) {
+	now := time.Date(2020, 10, 29, 12, 30, 0, 0, time.UTC)
+	header := "# Written by up (Ultimate Plumber) v" + version + " https://github.com/akavel/up\n" +
+		"# Date: 2020-10-29 12:30:00 +0000\n" +
+		"# Input: files: a.log 'b c.log'\n"
+	tests := []struct {
+		comment string
+		shell   []string
+		want    string
+	}{
+		{
+			comment: "shell with absolute path",
+			shell:   []string{"/bin/sh", "-c"},
+			want:    "#!/bin/sh\n" + header + "grep 'a b' | wc -l\n",
+		},
+		{
+			comment: "shell found in $PATH, with pipefail",
+			shell:   []string{"bash", "-c"},
+			want:    "#!/usr/bin/env bash\n" + header + "set -o pipefail\ngrep 'a b' | wc -l\n",
+		},
+		{
+			comment: "multi-word shell",
+			shell:   []string{"/usr/bin/zsh", "-e", "-c"},
+			want:    "#!/usr/bin/env -S /usr/bin/zsh -e\n" + header + "set -o pipefail\ngrep 'a b' | wc -l\n",
+		},
+		{
+			comment: "shell not using -c",
+			shell:   []string{"nu", "--commands"},
+			want:    "#!/bin/sh\n" + header + "exec nu --commands \"$(cat <<'UP_PIPELINE'\ngrep 'a b' | wc -l\nUP_PIPELINE\n)\"\n",
+		},
+		{
+			comment: "shell arguments which can't be put in shebang",
+			shell:   []string{"bash", "-O", "$x y", "-c"},
+			want:    "#!/bin/sh\n" + header + "exec bash -O '$x y' -c \"$(cat <<'UP_PIPELINE'\ngrep 'a b' | wc -l\nUP_PIPELINE\n)\"\n",
+		},
+	}
+
+	for _, tt := range tests {
+		have := scriptText(tt.shell, "grep 'a b' | wc -l", "files: a.log 'b c.log'", now)
+		if have != tt.want {
+			t.Errorf("%q: bad script\nwant: %q\nhave: %q", tt.comment, tt.want, have)
+		}
+		command, pipefail := scriptCommand(have)
+		if command != "grep 'a b' | wc -l" {
+			t.Errorf("%q: bad command read back from script: %q", tt.comment, command)
+		}
+		if want := strings.Contains(tt.want, "set -o pipefail"); pipefail != want {
+			t.Errorf("%q: want pipefail %v, have %v", tt.comment, want, pipefail)
+		}
+	}
+}
+
+func TestBuf_Detach(t *testing.T) {
+	// Input stream which stays idle, like `tail -f`
+	r, w := io.Pipe()
+	buf := NewBuf(1024).StartCapturing(r, func() {})
+	w.Write([]byte("abc"))
+	for string(buf.Bytes()) != "abc" {
+		time.Sleep(time.Millisecond)
+	}
+
+	detached := make(chan io.Reader)
+	go func() { detached <- buf.Detach() }()
+	var rest io.Reader
+	select {
+	case rest = <-detached:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Detach blocked on a pending read")
+	}
+
+	go func() {
+		w.Write([]byte("def"))
+		w.Close()
+	}()
+	have, err := ioutil.ReadAll(rest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(have) != "abcdef" {
+		t.Errorf("bad detached input: %q", have)
+	}
+	if string(buf.Bytes()) != "abc" {
+		t.Errorf("data read after Detach went to the buffer: %q", buf.Bytes())
+	}
+}
+
+func TestMain(m *testing.M) {
+	// Tests may run pipelines with limits, which re-execute the test binary
+	// as a helper, like up itself
+	if spec := os.Getenv(helperEnv); spec != "" {
+		runHelper(spec, os.Args[1:])
+	}
+	os.Exit(m.Run())
+}
+
+func Test_Subprocess_MemLimit(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("limits are tested on Linux only")
+	}
+	defer func(memLimit0 int) { *memLimit = memLimit0 }(*memLimit)
+	*memLimit = 64
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{`awk 'BEGIN { s = "x"; while (1) s = s s }'`, " MiB of the --mem-limit of 64 MiB)"},
+		{"exit 1", "exit status 1 (used "},
+		{"echo ok", "ok\n"},
+	}
+	for _, tt := range tests {
+		// Size of the input is also the limit of size of the output
+		input := NewBufString(strings.Repeat("x\n", 1024))
+		p := StartSubprocess([]string{"/bin/sh", "-c"}, tt.command, input, func() {})
+		output, _ := ioutil.ReadAll(p.Buf.NewReader(true))
+		if !strings.Contains(string(output), tt.want) {
+			t.Errorf("%q: want output with %q, have %q", tt.command, tt.want, output)
+		}
+	}
+}
+
+func Test_Subprocess_ExceededLimit(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("limits are tested on Linux only")
+	}
+	tests := []struct {
+		comment  string
+		command  string
+		timeout  time.Duration
+		cpuLimit time.Duration
+		memLimit int
+		want     string
+	}{
+		{
+			comment: "timeout",
+			command: "sleep 30",
+			timeout: 200 * time.Millisecond,
+			want:    "timeout of 200ms exceeded",
+		},
+		{
+			comment:  "CPU time limit",
+			command:  "while :; do :; done",
+			cpuLimit: time.Second,
+			want:     "CPU time limit of 1s exceeded",
+		},
+		{
+			comment:  "CPU time limit, in a subprocess",
+			command:  "sh -c 'while :; do :; done'; exit $?",
+			cpuLimit: time.Second,
+			want:     "CPU time limit of 1s exceeded",
+		},
+		{
+			comment:  "SIGKILL not caused by CPU time limit",
+			command:  "kill -9 $$",
+			cpuLimit: time.Second,
+			want:     "",
+		},
+		{
+			comment:  "memory limit, which can't be told apart from other failures",
+			command:  `awk 'BEGIN { s = "x"; while (1) s = s s }'`,
+			memLimit: 64,
+			want:     "",
+		},
+	}
+
+	defer func(timeout0, cpuLimit0 time.Duration, memLimit0 int) {
+		*timeout, *cpuLimit, *memLimit = timeout0, cpuLimit0, memLimit0
+	}(*timeout, *cpuLimit, *memLimit)
+	for _, tt := range tests {
+		*timeout, *cpuLimit, *memLimit = tt.timeout, tt.cpuLimit, tt.memLimit
+		// Size of the input is also the limit of size of the output
+		input := NewBufString(strings.Repeat("x\n", 1024))
+		p := StartSubprocess([]string{"/bin/sh", "-c"}, tt.command, input, func() {})
+		output, _ := ioutil.ReadAll(p.Buf.NewReader(true))
+		if have := p.ExceededLimit(); have != tt.want {
+			t.Errorf("%q: want %q, have %q (output: %q)", tt.comment, tt.want, have, output)
+		}
+	}
+}
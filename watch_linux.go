@@ -0,0 +1,87 @@
+// Copyright 2018 The up AUTHORS
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// watchDelay is how long to wait for more changes after a file was modified,
+// before reporting it.
+const watchDelay = 100 * time.Millisecond
+
+// watchFiles calls changed whenever any of the files at paths is modified,
+// replaced or created. Directories containing the files are watched, so that
+// files replaced by editors or rotated by log rotation tools are noticed too.
+func watchFiles(paths []string, changed func()) error {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC)
+	if err != nil {
+		return err
+	}
+	dirs := map[int]string{}
+	watched := map[string]bool{}
+	for _, path := range paths {
+		path, err := filepath.Abs(path)
+		if err != nil {
+			unix.Close(fd)
+			return err
+		}
+		wd, err := unix.InotifyAddWatch(fd, filepath.Dir(path),
+			unix.IN_MODIFY|unix.IN_CLOSE_WRITE|unix.IN_CREATE|unix.IN_MOVED_TO|unix.IN_DELETE)
+		if err != nil {
+			unix.Close(fd)
+			return err
+		}
+		dirs[wd] = filepath.Dir(path)
+		watched[path] = true
+	}
+
+	go func() {
+		// Writing a file usually generates a burst of events; wait until it's
+		// over, to not reload a half-written file more times than needed
+		var timer *time.Timer
+		buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+		for {
+			n, err := unix.Read(fd, buf)
+			if err == unix.EINTR {
+				continue
+			} else if err != nil {
+				log.Printf("watching files failed: %s", err)
+				return
+			}
+			for i := 0; i+unix.SizeofInotifyEvent <= n; {
+				ev := (*unix.InotifyEvent)(unsafe.Pointer(&buf[i]))
+				name := buf[i+unix.SizeofInotifyEvent : i+unix.SizeofInotifyEvent+int(ev.Len)]
+				i += unix.SizeofInotifyEvent + int(ev.Len)
+				path := filepath.Join(dirs[int(ev.Wd)], strings.TrimRight(string(name), "\x00"))
+				if !watched[path] {
+					continue
+				}
+				if timer == nil {
+					timer = time.AfterFunc(watchDelay, changed)
+				} else {
+					timer.Reset(watchDelay)
+				}
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,61 @@
+// Copyright 2018 The up AUTHORS
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// watchInterval is how often files are checked for changes.
+const watchInterval = time.Second
+
+// watchFiles calls changed whenever any of the files at paths is modified,
+// replaced or created. As there's no portable way to get notified about it,
+// the files are checked periodically.
+func watchFiles(paths []string, changed func()) error {
+	stat := func() []os.FileInfo {
+		infos := make([]os.FileInfo, len(paths))
+		for i, path := range paths {
+			infos[i], _ = os.Stat(path)
+		}
+		return infos
+	}
+	go func() {
+		last := stat()
+		for range time.Tick(watchInterval) {
+			infos := stat()
+			for i := range infos {
+				if modified(last[i], infos[i]) {
+					changed()
+					break
+				}
+			}
+			last = infos
+		}
+	}()
+	return nil
+}
+
+// modified checks if a file was changed, judging by its metadata.
+func modified(old, new os.FileInfo) bool {
+	if old == nil || new == nil {
+		return old != new
+	}
+	return !old.ModTime().Equal(new.ModTime()) || old.Size() != new.Size() || !os.SameFile(old, new)
+}
@@ -0,0 +1,367 @@
+// Copyright 2018 The up AUTHORS
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// webUI serves a web page mirroring the pipeline command and its output,
+// updated live over WebSocket. The command can be edited from the page too.
+type webUI struct {
+	// onCommand is called when the command is edited on the page; run is true
+	// if user asked to run it
+	onCommand func(command string, run bool)
+	// The page allows running commands, so only requests with the token
+	// generated for the session, addressed to one of hosts, are accepted;
+	// hosts is nil if any host is allowed
+	token string
+	hosts map[string]bool
+	addr  string
+
+	mu        sync.Mutex // guards the following fields
+	clients   map[*webClient]bool
+	command   string
+	output    *Buf
+	current   bool        // is the output of the displayed command?
+	last      []byte      // the last message sent to the pages
+	sent      webSnapshot // the state in the last message
+	notifying bool
+}
+
+// webClient is a page connected over WebSocket. Messages are sent to it in
+// background, so that a slow client doesn't block anybody; if it can't keep
+// up, stale messages are dropped, as each of them has the full state.
+type webClient struct {
+	conn    *wsConn
+	pending chan []byte
+}
+
+// webState is sent to the page whenever the command or output changes.
+type webState struct {
+	Command   string `json:"command"`
+	Current   bool   `json:"current"`
+	Output    string `json:"output"`
+	Complete  bool   `json:"complete"`
+	Truncated bool   `json:"truncated"`
+}
+
+// webCommand is sent by the page when user edits the command.
+type webCommand struct {
+	Command string `json:"command"`
+	Run     bool   `json:"run"`
+}
+
+// webOutputLimit is the maximum size of output sent to the page; it's shown
+// in a browser, so there's little point in sending more.
+const webOutputLimit = 4 * 1024 * 1024
+
+// loopbackAddress returns true if addr (like 127.0.0.1:8080) is an address on
+// local host only.
+func loopbackAddress(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return host == "localhost" || ip != nil && ip.IsLoopback()
+}
+
+// startWebUI starts serving the web page on l, in background. Unless remote
+// is true, l must be listening on a loopback address, and the page accepts
+// only requests addressed to local host (which protects against DNS
+// rebinding attacks).
+func startWebUI(l net.Listener, remote bool, onCommand func(command string, run bool)) (*webUI, error) {
+	token := make([]byte, 16)
+	_, err := rand.Read(token)
+	if err != nil {
+		return nil, err
+	}
+	web := &webUI{
+		onCommand: onCommand,
+		token:     hex.EncodeToString(token),
+		addr:      l.Addr().String(),
+		clients:   map[*webClient]bool{},
+	}
+	if !remote {
+		_, port, _ := net.SplitHostPort(web.addr)
+		web.hosts = map[string]bool{
+			web.addr:                            true,
+			net.JoinHostPort("localhost", port): true,
+			net.JoinHostPort("127.0.0.1", port): true,
+			net.JoinHostPort("::1", port):       true,
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		if !web.authorized(r) {
+			http.Error(w, "forbidden: open the address shown by up, including the token", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(webPage))
+	})
+	mux.HandleFunc("/ws", web.serveWebSocket)
+	go func() {
+		err := http.Serve(l, mux)
+		log.Printf("web UI stopped: %s", err)
+	}()
+	return web, nil
+}
+
+// URL returns the address of the page, which must be opened in a browser.
+func (web *webUI) URL() string {
+	return "http://" + web.addr + "/?token=" + web.token
+}
+
+// authorized checks if r has the token of the session, and is addressed to
+// one of allowed hosts.
+func (web *webUI) authorized(r *http.Request) bool {
+	if web.hosts != nil && !web.hosts[r.Host] {
+		return false
+	}
+	token := r.URL.Query().Get("token")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(web.token)) == 1
+}
+
+func (web *webUI) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	// Also, other websites opened in the browser must not be able to connect
+	// to us
+	if !web.authorized(r) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if origin, err := url.Parse(r.Header.Get("Origin")); err != nil || origin.Host != r.Host {
+		http.Error(w, "cross-origin requests are not allowed", http.StatusForbidden)
+		return
+	}
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		log.Printf("web UI: %s", err)
+		return
+	}
+	client := &webClient{conn: conn, pending: make(chan []byte, 1)}
+	go client.run()
+	web.mu.Lock()
+	web.clients[client] = true
+	if web.last != nil {
+		client.send(web.last)
+	}
+	// The last message may be outdated, if nobody was connected for a while
+	web.schedule()
+	web.mu.Unlock()
+	defer func() {
+		web.mu.Lock()
+		delete(web.clients, client)
+		close(client.pending)
+		web.mu.Unlock()
+		conn.Close()
+	}()
+
+	for {
+		data, err := conn.ReadText()
+		if err != nil {
+			return
+		}
+		var cmd webCommand
+		err = json.Unmarshal(data, &cmd)
+		if err != nil {
+			log.Printf("web UI: %s", err)
+			continue
+		}
+		web.onCommand(cmd.Command, cmd.Run)
+	}
+}
+
+// send queues msg to be sent to the client, replacing a stale message which
+// was not sent yet. It must be called with web.mu locked.
+func (c *webClient) send(msg []byte) {
+	select {
+	case <-c.pending:
+	default:
+	}
+	c.pending <- msg
+}
+
+// run sends queued messages to the client, until it's disconnected.
+func (c *webClient) run() {
+	for msg := range c.pending {
+		err := c.conn.WriteText(msg)
+		if err != nil {
+			c.conn.Close()
+		}
+	}
+}
+
+// Update makes the page show command, and output which will be followed
+// until complete. Updates are sent to the page at most once in a short while.
+func (web *webUI) Update(command string, current bool, output *Buf) {
+	if web == nil {
+		return
+	}
+	web.mu.Lock()
+	defer web.mu.Unlock()
+	web.command, web.current, web.output = command, current, output
+	web.schedule()
+}
+
+// webSnapshot describes what is shown on the page. Output of the pipeline is
+// only appended to, so it's enough to compare its size.
+type webSnapshot struct {
+	command  string
+	current  bool
+	output   *Buf
+	size     int
+	complete bool
+}
+
+// snapshot returns the current state of the page. It must be called with
+// web.mu locked.
+func (web *webUI) snapshot() webSnapshot {
+	return webSnapshot{
+		command:  web.command,
+		current:  web.current,
+		output:   web.output,
+		size:     len(web.output.Bytes()),
+		complete: web.output.Complete(),
+	}
+}
+
+// schedule makes the state sent to the pages soon, unless it's already
+// scheduled. It must be called with web.mu locked.
+func (web *webUI) schedule() {
+	if web.notifying || web.output == nil {
+		return
+	}
+	web.notifying = true
+	time.AfterFunc(200*time.Millisecond, web.send)
+}
+
+func (web *webUI) send() {
+	web.mu.Lock()
+	state := web.snapshot()
+	// Don't copy and encode the output if nobody would see it, or if it
+	// didn't change (a page which connects later triggers sending it)
+	if len(web.clients) == 0 || state == web.sent {
+		web.notifying = false
+		web.mu.Unlock()
+		return
+	}
+	web.mu.Unlock()
+
+	data := state.output.Bytes()[:state.size]
+	msg := webState{
+		Command:   state.command,
+		Current:   state.current,
+		Complete:  state.complete,
+		Truncated: len(data) > webOutputLimit,
+	}
+	if msg.Truncated {
+		data = data[:webOutputLimit]
+	}
+	msg.Output = string(data)
+	buf, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("web UI: %s", err)
+	}
+
+	web.mu.Lock()
+	defer web.mu.Unlock()
+	web.notifying = false
+	web.sent = state
+	if err == nil && !bytes.Equal(buf, web.last) {
+		web.last = buf
+		for client := range web.clients {
+			client.send(buf)
+		}
+	}
+	// The state could change while it was encoded
+	if web.snapshot() != state {
+		web.schedule()
+	}
+}
+
+const webPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>up - the Ultimate Plumber</title>
+<style>
+  body { margin: 0; font-family: monospace; background: #fff; color: #000; }
+  #bar { position: sticky; top: 0; display: flex; background: #008; color: #fff; }
+  #bar.edited { background: #00f; }
+  #bar span { padding: 4px; white-space: pre; }
+  #command { flex: 1; font: inherit; background: inherit; color: inherit; border: none; padding: 4px; }
+  #output { margin: 0; padding: 4px; white-space: pre; }
+  #status { padding: 4px; color: #800; }
+</style>
+</head>
+<body>
+<div id="bar"><span>|</span><input id="command" autofocus spellcheck="false"></div>
+<pre id="output"></pre>
+<div id="status"></div>
+<script>
+var command = document.getElementById("command");
+var bar = document.getElementById("bar");
+var output = document.getElementById("output");
+var statusLine = document.getElementById("status");
+var ws;
+function connect() {
+  var token = new URLSearchParams(location.search).get("token");
+  ws = new WebSocket((location.protocol == "https:" ? "wss://" : "ws://") + location.host + "/ws?token=" + encodeURIComponent(token));
+  ws.onmessage = function(ev) {
+    var state = JSON.parse(ev.data);
+    if (document.activeElement !== command || command.value === state.command) {
+      command.value = state.command;
+    }
+    bar.className = state.current ? "" : "edited";
+    output.textContent = state.output;
+    statusLine.textContent = state.truncated ? "(output truncated)" : state.complete ? "" : "~";
+  };
+  ws.onclose = function() {
+    statusLine.textContent = "disconnected from up";
+    setTimeout(connect, 1000);
+  };
+}
+function send(run) {
+  if (ws.readyState == WebSocket.OPEN) {
+    ws.send(JSON.stringify({command: command.value, run: run}));
+  }
+}
+command.addEventListener("input", function() { send(false); });
+command.addEventListener("keydown", function(ev) {
+  if (ev.key == "Enter") {
+    send(true);
+  }
+});
+connect();
+</script>
+</body>
+</html>
+`
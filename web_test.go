@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_loopbackAddress(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"127.0.0.1:8080": true,
+		"127.0.0.2:8080": true,
+		"[::1]:8080":     true,
+		"localhost:8080": true,
+		":8080":          false,
+		"0.0.0.0:8080":   false,
+		"[::]:8080":      false,
+		"10.0.0.1:8080":  false,
+		"example.com:80": false,
+		"127.0.0.1":      false,
+	} {
+		if have := loopbackAddress(addr); have != want {
+			t.Errorf("%q: want %v, have %v", addr, want, have)
+		}
+	}
+}
+
+func Test_webUI_authorized(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	web, err := startWebUI(l, false, func(string, bool) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	tests := []struct {
+		comment string
+		host    string
+		target  string
+		want    bool
+	}{
+		{"page", l.Addr().String(), "/?token=" + web.token, true},
+		{"websocket", l.Addr().String(), "/ws?token=" + web.token, true},
+		{"localhost", "localhost:" + port, "/?token=" + web.token, true},
+		{"no token", l.Addr().String(), "/", false},
+		{"bad token", l.Addr().String(), "/?token=0123", false},
+		{"DNS rebinding", "attacker.example.com:" + port, "/?token=" + web.token, false},
+		{"other port", "localhost:1", "/?token=" + web.token, false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.target, nil)
+		r.Host = tt.host
+		if have := web.authorized(r); have != tt.want {
+			t.Errorf("%s: want %v, have %v", tt.comment, tt.want, have)
+		}
+	}
+
+	other, err := startWebUI(l, false, func(string, bool) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.token == web.token {
+		t.Errorf("token is not random: %q", web.token)
+	}
+}
+
+func Test_webClient_send(t *testing.T) {
+	// A client which doesn't keep up gets only the latest message
+	c := &webClient{pending: make(chan []byte, 1)}
+	for _, msg := range []string{"1", "2", "3"} {
+		c.send([]byte(msg))
+	}
+	if have := string(<-c.pending); have != "3" {
+		t.Errorf("want latest message, have %q", have)
+	}
+}
+
+func Test_webUI_send(t *testing.T) {
+	web := &webUI{clients: map[*webClient]bool{}}
+	web.command, web.output = "grep x", NewBufString("x\n")
+
+	// Nothing is encoded while nobody is connected
+	web.send()
+	if web.last != nil {
+		t.Errorf("message encoded without clients: %q", web.last)
+	}
+
+	c := &webClient{pending: make(chan []byte, 1)}
+	web.clients[c] = true
+	web.send()
+	select {
+	case msg := <-c.pending:
+		if want := `{"command":"grep x","current":false,"output":"x\n","complete":true,"truncated":false}`; string(msg) != want {
+			t.Errorf("want %s, have %s", want, msg)
+		}
+	default:
+		t.Fatal("no message sent")
+	}
+
+	// Unchanged state isn't sent again
+	web.send()
+	select {
+	case msg := <-c.pending:
+		t.Errorf("unchanged state sent again: %s", msg)
+	default:
+	}
+	web.command = "grep y"
+	web.send()
+	if len(c.pending) != 1 {
+		t.Errorf("changed state not sent")
+	}
+}
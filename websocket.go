@@ -0,0 +1,209 @@
+// Copyright 2018 The up AUTHORS
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// wsConn is a server side of a WebSocket connection (RFC 6455). Only text
+// messages are supported.
+type wsConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	mu   sync.Mutex // serializes writes
+}
+
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+// wsWriteTimeout is how long a client may not read messages sent to it,
+// before the connection fails.
+const wsWriteTimeout = 10 * time.Second
+
+// wsMaxMessage is the maximum size of a message accepted from a client.
+const wsMaxMessage = 1024 * 1024
+
+// upgradeWebSocket performs the WebSocket opening handshake, and takes over
+// the connection of the HTTP request.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") || key == "" {
+		http.Error(w, "expected WebSocket handshake", http.StatusBadRequest)
+		return nil, errors.New("bad WebSocket handshake")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "cannot upgrade connection", http.StatusInternalServerError)
+		return nil, errors.New("cannot hijack HTTP connection")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	_, err = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + wsAcceptKey(key) + "\r\n\r\n")
+	if err == nil {
+		err = rw.Flush()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, r: rw.Reader}, nil
+}
+
+// wsAcceptKey computes the value of Sec-WebSocket-Accept header for a
+// Sec-WebSocket-Key sent by client.
+func wsAcceptKey(key string) string {
+	h := sha1.Sum([]byte(key + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h[name] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// WriteText sends a text message.
+func (c *wsConn) WriteText(data []byte) error {
+	return c.writeFrame(wsText, data)
+}
+
+func (c *wsConn) writeFrame(opcode byte, data []byte) error {
+	header := []byte{0x80 | opcode, 0} // FIN bit and opcode; server doesn't mask
+	switch n := len(data); {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xffff:
+		header[1] = 126
+		header = append(header, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(n))
+	default:
+		header[1] = 127
+		header = append(header, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(n))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	_, err := c.conn.Write(append(header, data...))
+	return err
+}
+
+// ReadText waits for a text message from the client. Control frames are
+// handled transparently; io.EOF is returned when the client closes the
+// connection.
+func (c *wsConn) ReadText() ([]byte, error) {
+	var message []byte
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch opcode {
+		case wsText, wsContinuation:
+			message = append(message, payload...)
+			if len(message) > wsMaxMessage {
+				return nil, errors.New("websocket: message too big")
+			}
+			if fin {
+				return message, nil
+			}
+		case wsClose:
+			c.writeFrame(wsClose, nil)
+			return nil, io.EOF
+		case wsPing:
+			err := c.writeFrame(wsPong, payload)
+			if err != nil {
+				return nil, err
+			}
+		case wsPong:
+		default:
+			return nil, errors.New("websocket: unsupported message type")
+		}
+	}
+}
+
+func (c *wsConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var header [2]byte
+	_, err = io.ReadFull(c.r, header[:])
+	if err != nil {
+		return false, 0, nil, err
+	}
+	fin, opcode = header[0]&0x80 != 0, header[0]&0x0f
+	if header[1]&0x80 == 0 {
+		return false, 0, nil, errors.New("websocket: unmasked frame from client")
+	}
+	n := uint64(header[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		_, err = io.ReadFull(c.r, ext[:])
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		_, err = io.ReadFull(c.r, ext[:])
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if err != nil {
+		return false, 0, nil, err
+	}
+	if n > wsMaxMessage {
+		return false, 0, nil, errors.New("websocket: message too big")
+	}
+	var mask [4]byte
+	_, err = io.ReadFull(c.r, mask[:])
+	if err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, n)
+	_, err = io.ReadFull(c.r, payload)
+	if err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+func (c *wsConn) Close() error {
+	return c.conn.Close()
+}
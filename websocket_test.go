@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"testing"
+)
+
+func Test_wsAcceptKey(t *testing.T) {
+	// Example from RFC 6455, section 1.3
+	have := wsAcceptKey("dGhlIHNhbXBsZSBub25jZQ==")
+	if want := "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="; have != want {
+		t.Errorf("want %q, have %q", want, have)
+	}
+}
+
+func Test_wsConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	c := &wsConn{conn: server, r: bufio.NewReader(server)}
+	defer c.Close()
+
+	go func() {
+		// Examples from RFC 6455, section 5.7: a masked text message, a
+		// fragmented one, and a ping
+		client.Write([]byte{0x81, 0x85, 0x37, 0xfa, 0x21, 0x3d, 0x7f, 0x9f, 0x4d, 0x51, 0x58})
+		client.Write([]byte{0x01, 0x83, 0, 0, 0, 0, 'H', 'e', 'l'})
+		client.Write([]byte{0x89, 0x80, 0, 0, 0, 0})
+		client.Write([]byte{0x80, 0x82, 0, 0, 0, 0, 'l', 'o'})
+		client.Write([]byte{0x88, 0x80, 0, 0, 0, 0})
+	}()
+	pong := make(chan []byte)
+	go func() {
+		frame := make([]byte, 2)
+		io.ReadFull(client, frame)
+		pong <- frame
+		io.ReadFull(client, frame) // reply to close
+	}()
+
+	for _, want := range []string{"Hello", "Hello"} {
+		have, err := c.ReadText()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(have) != want {
+			t.Errorf("want %q, have %q", want, have)
+		}
+	}
+	if frame := <-pong; !bytes.Equal(frame, []byte{0x8a, 0x00}) {
+		t.Errorf("bad pong: % x", frame)
+	}
+	if _, err := c.ReadText(); err != io.EOF {
+		t.Errorf("want EOF after close, have: %v", err)
+	}
+}